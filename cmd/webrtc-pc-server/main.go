@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/server"
+)
+
+// envPrefix is the prefix of all environment variables that override the configuration
+const envPrefix = "PCSERVER"
+
+// configFlags binds command line flags to configuration fields. The flags are only
+// applied when they were set explicitly so they override the file and environment.
+type configFlags struct {
+	apply map[string]func()
+}
+
+func (f *configFlags) String(name string, dst *string, usage string) {
+	v := flag.String(name, *dst, usage)
+	f.apply[name] = func() { *dst = *v }
+}
+
+func (f *configFlags) Bool(name string, dst *bool, usage string) {
+	v := flag.Bool(name, *dst, usage)
+	f.apply[name] = func() { *dst = *v }
+}
+
+func (f *configFlags) Int(name string, dst *int, usage string) {
+	v := flag.Int(name, *dst, usage)
+	f.apply[name] = func() { *dst = *v }
+}
+
+func (f *configFlags) Uint32(name string, dst *uint32, usage string) {
+	v := flag.Uint(name, uint(*dst), usage)
+	f.apply[name] = func() {
+		if uint64(*v) > math.MaxUint32 {
+			log.Fatalf("-%s: %d exceeds %d", name, *v, uint64(math.MaxUint32))
+		}
+		*dst = uint32(*v)
+	}
+}
+
+func (f *configFlags) Uint8(name string, dst *uint8, usage string) {
+	v := flag.Uint(name, uint(*dst), usage)
+	f.apply[name] = func() {
+		if *v > math.MaxUint8 {
+			log.Fatalf("-%s: %d exceeds %d", name, *v, math.MaxUint8)
+		}
+		*dst = uint8(*v)
+	}
+}
+
+func (f *configFlags) Duration(name string, dst *server.Duration, usage string) {
+	v := flag.Duration(name, time.Duration(*dst), usage)
+	f.apply[name] = func() { *dst = server.Duration(*v) }
+}
+
+func (f *configFlags) Interceptors(name string, dst *[]server.InterceptorSet, usage string) {
+	names := make([]string, len(*dst))
+	for i, set := range *dst {
+		names[i] = string(set)
+	}
+	v := flag.String(name, strings.Join(names, ","), usage)
+	f.apply[name] = func() {
+		sets := make([]server.InterceptorSet, 0)
+		for _, name := range strings.Split(*v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				sets = append(sets, server.InterceptorSet(name))
+			}
+		}
+		*dst = sets
+	}
+}
+
+func (f *configFlags) Transport(name string, dst *server.FrameTransport, usage string) {
+	v := flag.String(name, string(*dst), usage)
+	f.apply[name] = func() { *dst = server.FrameTransport(*v) }
+}
+
+func (f *configFlags) Float64(name string, dst *float64, usage string) {
+	v := flag.Float64(name, *dst, usage)
+	f.apply[name] = func() { *dst = *v }
+}
+
+func (f *configFlags) Float64s(name string, dst *[]float64, usage string) {
+	values := make([]string, len(*dst))
+	for i, v := range *dst {
+		values[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	v := flag.String(name, strings.Join(values, ","), usage)
+	f.apply[name] = func() {
+		floats := make([]float64, 0)
+		for _, value := range strings.Split(*v, ",") {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				log.Fatalf("-%s: %v", name, err)
+			}
+			floats = append(floats, n)
+		}
+		*dst = floats
+	}
+}
+
+func main() {
+	config := server.DefaultServerConfig()
+	pcConfig := &config.PeerConnection
+	flags := &configFlags{apply: make(map[string]func())}
+
+	configPath := flag.String("config", "", "JSON configuration file (.json, other formats are rejected), environment variables ("+envPrefix+"_*) and flags override it")
+	flags.String("v", &config.VirtualWallIP, "Use virtual wall ip filter")
+	flags.Bool("p", &config.UseProxy, "Use Proxy Input")
+	flags.String("cap", &config.ProxyCaptureAddr, "Use as a proxy with specified port")
+	flags.String("srv", &config.ProxyServerAddr, "Use as a proxy with specified port")
+	flags.String("d", &config.ContentDirectory, "Content directory")
+	flags.Uint32("f", &config.ContentFrameRate, "Frame rate that is used when using files instead of proxy")
+	flags.String("s", &config.SignalingAddr, "Signaling server IP")
+	flags.Int("c", &config.MaxClients, "Number of clients")
+	flags.String("m", &config.ResultDirectory, "Directory of the result files")
+	flags.Bool("i", &config.IsIndi, "Use Individual Encoding")
+	flags.Bool("w", &config.WaitForClientOffer, "Wait for the client to send an offer or hello instead of offering on connect")
+	flags.Bool("l", &config.LegacySignaling, "Use the legacy '@' delimited signaling format")
+	flags.Duration("t", &config.ShutdownTimeout, "Maximum time to drain clients on shutdown")
+	flags.Int("min-bitrate", &pcConfig.MinBitrate, "GCC minimum bitrate (bps)")
+	flags.Int("initial-bitrate", &pcConfig.InitialBitrate, "GCC initial bitrate (bps)")
+	flags.Int("max-bitrate", &pcConfig.MaxBitrate, "GCC maximum bitrate (bps)")
+	flags.Duration("twcc-interval", &pcConfig.TWCCSendInterval, "Interval at which TWCC feedback is sent")
+	flags.Uint32("sctp-buffer", &pcConfig.SCTPMaxReceiveBufferSize, "SCTP maximum receive buffer size (bytes)")
+	flags.Duration("disconnect-timeout", &pcConfig.DisconnectTimeout, "Time a client can stay ICE disconnected")
+	flags.Interceptors("interceptors", &pcConfig.Interceptors, "Comma separated interceptor sets (nack, twcc, gcc, rtcp_reports, stats, rtx)")
+	flags.Duration("rtcp-interval", &pcConfig.RTCPReportInterval, "Interval of RTCP sender and receiver reports")
+	flags.Int("mtu", &pcConfig.MTU, "Maximum size of an RTP packet including header and extensions")
+	flags.Int("fragment-size", &pcConfig.FragmentSize, "Bytes of frame data per RTP packet")
+	flags.Uint8("packet-version", &pcConfig.FramePacketVersion, "Frame packet wire format, 1 is compact, 0 is the legacy fixed size format")
+	flags.Float64s("fec", &pcConfig.FECRatios, "Comma separated XOR parity packets per fragment for every layer, base layer first")
+	flags.Uint32("encoder-fps", &pcConfig.EncoderFrameRate, "Frame rate used to compute the per frame bitrate budget")
+	flags.Uint32("ring-size", &pcConfig.ReceiveRingSize, "Number of received frames that are kept per client")
+	flags.Duration("reassembly-deadline", &pcConfig.ReassemblyDeadline, "Time after its first fragment at which an incomplete received frame is dropped")
+	flags.Uint32("max-frame-size", &pcConfig.MaxFrameSize, "Largest received frame in bytes")
+	flags.Uint32("reassembly-buffer", &pcConfig.ReassemblyBufferSize, "Bytes that incomplete received frames of a track can take")
+	flags.Int("send-queue", &pcConfig.SendQueueSize, "Number of encoded frames that can wait to be sent per client")
+	flags.Duration("send-deadline", &pcConfig.SendDeadline, "Time after its capture at which a frame is no longer sent")
+	flags.Float64("pacing-multiplier", &pcConfig.PacingMultiplier, "RTP packets are paced at the estimated bitrate times this multiplier, 0 disables pacing")
+	flags.Int("pacing-burst", &pcConfig.PacingBurst, "Bytes the pacer sends without waiting")
+	flags.Float64("pose-smoothing", &pcConfig.PoseSmoothing, "Weight of a new viewer pose sample, 1 disables smoothing")
+	flags.Duration("pose-prediction", &pcConfig.PosePrediction, "How far ahead the viewer pose is predicted for layer selection")
+	flags.Float64("hfov", &pcConfig.HorizontalFOV, "Horizontal field of view of the viewers in degrees")
+	flags.Float64("vfov", &pcConfig.VerticalFOV, "Vertical field of view of the viewers in degrees")
+	flags.Int("layer-streams", &pcConfig.LayerStreams, "Number of RTP streams, layer i of a multi-layer frame is sent on stream i")
+	flags.Transport("transport", &pcConfig.Transport, "Transport of outgoing frames, rtp or datachannel")
+	flags.Bool("dc-ordered", &pcConfig.DataChannelOrdered, "Deliver data channel messages in order")
+	flags.Int("dc-max-retransmits", &pcConfig.DataChannelMaxRetransmits, "Retransmissions of a data channel message, -1 is reliable")
+	flags.Duration("dc-max-lifetime", &pcConfig.DataChannelMaxPacketLifeTime, "Time a data channel message is retransmitted, 0 is reliable")
+	flags.Int("dc-fragment-size", &pcConfig.DataChannelFragmentSize, "Bytes of frame data per data channel message")
+	flags.Uint32("save-interval", &pcConfig.ResultSaveInterval, "Only every n-th frame is written to the result files")
+	flag.Parse()
+
+	if *configPath != "" {
+		if err := server.LoadConfigFile(*configPath, &config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := config.ApplyEnv(envPrefix); err != nil {
+		log.Fatal(err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if apply, ok := flags.apply[f.Name]; ok {
+			apply()
+		}
+	})
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	srv := server.NewServer(config)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Start(); err != nil {
+		log.Fatal(err)
+	}
+	<-ctx.Done()
+	stop()
+
+	log.Printf("Shutting down, draining clients for at most %s", config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout))
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+	}
+}
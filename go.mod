@@ -1,27 +1,26 @@
-module goweb
+module github.com/MatthiasDeFre/webrtc-pc-server
 
 go 1.20
 
 require (
+	github.com/eapache/queue v1.1.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/pion/interceptor v0.1.16
 	github.com/pion/randutil v0.1.0
+	github.com/pion/rtcp v1.2.12
 	github.com/pion/rtp v1.7.13
 	github.com/pion/sdp/v3 v3.0.6
 	github.com/pion/webrtc/v3 v3.2.1
 )
 
 require (
-	github.com/Workiva/go-datastructures v1.1.0 // indirect
 	github.com/eapache/channels v1.1.0 // indirect
-	github.com/eapache/queue v1.1.0 // indirect
 	github.com/google/uuid v1.3.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/pion/datachannel v1.5.5 // indirect
 	github.com/pion/dtls/v2 v2.2.6 // indirect
 	github.com/pion/ice/v2 v2.3.2 // indirect
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/mdns v0.0.7 // indirect
-	github.com/pion/rtcp v1.2.12 // indirect
 	github.com/pion/sctp v1.8.7 // indirect
 	github.com/pion/srtp/v2 v2.0.12 // indirect
 	github.com/pion/stun v0.4.0 // indirect
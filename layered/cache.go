@@ -0,0 +1,44 @@
+package layered
+
+import "encoding/binary"
+
+// EncodeCache holds the frames encoded from one source frame by the layers that were selected, so
+// clients that select the same layers share one buffer instead of each getting a copy. Shared
+// buffers must not be modified. It is not safe for concurrent use.
+type EncodeCache struct {
+	hasFrame bool
+	frameNr  uint32
+	encoded  map[string][]byte
+}
+
+func NewEncodeCache() *EncodeCache {
+	return &EncodeCache{encoded: make(map[string][]byte)}
+}
+
+// Reset drops the cached frames when frameNr is not the source frame they were encoded from
+func (c *EncodeCache) Reset(frameNr uint32) {
+	if c.hasFrame && c.frameNr == frameNr {
+		return
+	}
+	c.hasFrame = true
+	c.frameNr = frameNr
+	c.encoded = make(map[string][]byte)
+}
+
+// get returns the frame encoded with the selection identified by key, encode is only called when
+// no client selected it before. A nil cache always encodes.
+func (c *EncodeCache) get(key []uint32, encode func() []byte) []byte {
+	if c == nil {
+		return encode()
+	}
+	k := make([]byte, 4*len(key))
+	for i, v := range key {
+		binary.LittleEndian.PutUint32(k[4*i:], v)
+	}
+	if data, ok := c.encoded[string(k)]; ok {
+		return data
+	}
+	data := encode()
+	c.encoded[string(k)] = data
+	return data
+}
@@ -0,0 +1,97 @@
+package layered
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEncodeCacheGet(t *testing.T) {
+	type step struct {
+		frameNr uint32
+		key     []uint32
+		// Whether the frame has to be encoded, false when it comes from the cache
+		miss bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"same layers", []step{{1, []uint32{0, 1}, true}, {1, []uint32{0, 1}, false}, {1, []uint32{0, 1}, false}}},
+		{"other layers", []step{{1, []uint32{0, 1}, true}, {1, []uint32{0}, true}, {1, []uint32{0, 1, 2}, true}, {1, []uint32{0}, false}}},
+		{"order matters", []step{{1, []uint32{0, 1}, true}, {1, []uint32{1, 0}, true}}},
+		{"no layers", []step{{1, nil, true}, {1, []uint32{}, false}, {1, []uint32{0}, true}}},
+		{"ids that share bytes", []step{{1, []uint32{1 << 8}, true}, {1, []uint32{1}, true}, {1, []uint32{0, 1}, true}}},
+		{"new frame", []step{{1, []uint32{0}, true}, {2, []uint32{0}, true}, {2, []uint32{0}, false}}},
+		{"same frame again", []step{{1, []uint32{0}, true}, {1, []uint32{0}, false}}},
+		{"frame 0 first", []step{{0, []uint32{0}, true}, {0, []uint32{0}, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewEncodeCache()
+			// Data returned for every key the last time it was encoded
+			encoded := make(map[string][]byte)
+			for i, s := range tt.steps {
+				c.Reset(s.frameNr)
+				called := false
+				data := []byte{byte(i)}
+				got := c.get(s.key, func() []byte {
+					called = true
+					return data
+				})
+				if called != s.miss {
+					t.Fatalf("step %d: encoded %t, want %t", i, called, s.miss)
+				}
+				k := fmt.Sprint(s.frameNr, s.key)
+				if s.miss {
+					encoded[k] = data
+				}
+				if &got[0] != &encoded[k][0] {
+					t.Errorf("step %d: got %v, want the buffer encoded in step %d", i, got, encoded[k][0])
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeCacheNil(t *testing.T) {
+	var c *EncodeCache
+	calls := 0
+	for i := 0; i < 2; i++ {
+		c.get([]uint32{0}, func() []byte {
+			calls++
+			return nil
+		})
+	}
+	if calls != 2 {
+		t.Errorf("encoded %d times, want 2", calls)
+	}
+}
+
+func TestEncodeMultiFrameCache(t *testing.T) {
+	policy := DefaultLayerPolicy()
+	policy.Categories = nil
+	l := NewLayeredEncoder(1, policy)
+	cache := NewEncodeCache()
+	frame := testFrame(0, 0, 1)
+	all := testBitrate(1 << 20)
+	base := testBitrate(testMainSize + testLayerSize)
+
+	cache.Reset(1)
+	first := l.EncodeMultiFrame(frame, all, nil, nil, cache)
+	shared := l.EncodeMultiFrame(frame, all, nil, nil, cache)
+	baseOnly := l.EncodeMultiFrame(frame, base, nil, nil, cache)
+	if len(first) == 0 || len(baseOnly) == 0 {
+		t.Fatalf("encoded %d and %d bytes", len(first), len(baseOnly))
+	}
+	if &first[0] != &shared[0] {
+		t.Error("clients with the same layers do not share the encoded frame")
+	}
+	if len(baseOnly) != testMainSize+testLayerSize {
+		t.Errorf("base layer frame has %d bytes, want %d", len(baseOnly), testMainSize+testLayerSize)
+	}
+
+	cache.Reset(2)
+	if next := l.EncodeMultiFrame(frame, all, nil, nil, cache); &next[0] == &first[0] {
+		t.Error("the next source frame reused the cached frame")
+	}
+}
@@ -0,0 +1,207 @@
+// Package layered selects the layers of multi-layer point cloud frames that fit a bitrate.
+package layered
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+type MultiLayerMainHeader struct {
+	NLayers uint32
+	MinX    float32
+	MinY    float32
+	MinZ    float32
+	MaxX    float32
+	MaxY    float32
+	MaxZ    float32
+}
+
+type MultiLayerSideHeader struct {
+	LayerID  uint32
+	FrameLen uint32
+}
+
+type LayeredEncoder struct {
+	Bitrate uint32
+	// Frame rate used to turn the bitrate into a per frame budget
+	FrameRate uint32
+	// Layer combinations of multi-layer frames by distance
+	Policy LayerPolicy
+}
+
+/*type PanZoom struct {
+	XPos  float32
+	YPos  float32
+	ZPos  float32
+	Yaw   float32
+	Pitch float32
+	Roll  float32
+}*/
+
+func NewLayeredEncoder(frameRate uint32, policy LayerPolicy) *LayeredEncoder {
+	return &LayeredEncoder{FrameRate: frameRate, Policy: policy}
+}
+
+// ParseLayers returns the byte ranges of the layers of a multi-layer frame, the main header
+// is part of the range of the first layer
+func ParseLayers(frame []byte) ([]pointcloud.Layer, error) {
+	var mainLHeader MultiLayerMainHeader
+	mainSize := uint32(unsafe.Sizeof(mainLHeader))
+	if len(frame) < int(mainSize) {
+		return nil, errors.New("frame is smaller than the main header")
+	}
+	if IsTiledFrame(frame) {
+		return nil, errors.New("tiled frames have no frame wide layers")
+	}
+	if err := binary.Read(bytes.NewReader(frame[:mainSize]), binary.LittleEndian, &mainLHeader); err != nil {
+		return nil, err
+	}
+	layers := make([]pointcloud.Layer, 0)
+	currentOffset := mainSize
+	for j := 0; j < int(mainLHeader.NLayers); j++ {
+		var shTemp MultiLayerSideHeader
+		sideSize := uint32(unsafe.Sizeof(shTemp))
+		if int(currentOffset+sideSize) > len(frame) {
+			return nil, fmt.Errorf("side header of layer %d does not fit", j)
+		}
+		if err := binary.Read(bytes.NewReader(frame[currentOffset:]), binary.LittleEndian, &shTemp); err != nil {
+			return nil, err
+		}
+		if int(currentOffset)+int(sideSize)+int(shTemp.FrameLen) > len(frame) {
+			return nil, fmt.Errorf("layer %d does not fit", shTemp.LayerID)
+		}
+		layers = append(layers, pointcloud.Layer{ID: shTemp.LayerID, Offset: currentOffset, Len: sideSize + shTemp.FrameLen})
+		currentOffset += sideSize + shTemp.FrameLen
+	}
+	if len(layers) > 0 {
+		layers[0].Len += layers[0].Offset
+		layers[0].Offset = 0
+	}
+	return layers, nil
+}
+
+// BaseLayerFrame returns a copy of a multi-layer frame that only contains its first layer, the
+// number of layers in the main header is set to 1. It returns nil for frames without layers.
+func BaseLayerFrame(frame *pointcloud.Frame) *pointcloud.Frame {
+	if len(frame.Layers) == 0 {
+		return nil
+	}
+	base := frame.Layers[0]
+	for _, l := range frame.Layers[1:] {
+		if l.Offset < base.Offset {
+			base = l
+		}
+	}
+	// The first layer holds the main header
+	if base.Offset != 0 || base.Len < uint32(unsafe.Sizeof(MultiLayerMainHeader{})) || int(base.Len) > len(frame.Data) {
+		return nil
+	}
+	data := append([]byte(nil), frame.Data[:base.Len]...)
+	binary.LittleEndian.PutUint32(data, 1)
+	return &pointcloud.Frame{
+		ClientID:    frame.ClientID,
+		FrameLen:    base.Len,
+		FrameNr:     frame.FrameNr,
+		Data:        data,
+		CaptureTime: frame.CaptureTime,
+		Layers:      []pointcloud.Layer{base},
+	}
+}
+
+// EncodeMultiFrame selects the layers of a multi-layer frame with the highest quality that fit the
+// bitrate. The distance between the viewer and the frame picks the category of the layer policy to
+// start from, frames outside the view frustum start from the last category. Without a viewport the
+// viewer is at the origin. Combinations that reference a layer the frame does not have are skipped.
+// The selection of the client keeps its quality from flapping and clients that select the same
+// layers share the frame in the cache, both can be nil.
+func (l *LayeredEncoder) EncodeMultiFrame(frame []byte, bitrate uint32, viewport *pointcloud.Viewport, selection *LayerSelection, cache *EncodeCache) []byte {
+	var mainLHeader MultiLayerMainHeader
+	mainSize := int(unsafe.Sizeof(mainLHeader))
+	layers, err := ParseLayers(frame)
+	if err != nil {
+		return nil
+	}
+	if err := binary.Read(bytes.NewReader(frame[:mainSize]), binary.LittleEndian, &mainLHeader); err != nil {
+		return nil
+	}
+	// Side header and data of every layer by ID
+	layerData := make(map[uint32][]byte, len(layers))
+	ids := make([]uint32, 0, len(layers))
+	for _, layer := range layers {
+		start := int(layer.Offset)
+		if start == 0 {
+			start = mainSize
+		}
+		// Malformed frame, the same layer is present twice
+		if _, ok := layerData[layer.ID]; ok {
+			return nil
+		}
+		layerData[layer.ID] = frame[start : layer.Offset+layer.Len]
+		ids = append(ids, layer.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	categories := l.Policy.Categories
+	dstID := 0
+	if len(categories) == 0 {
+		combos := make([][]uint32, len(ids))
+		for i := range ids {
+			combos[i] = ids[:i+1]
+		}
+		categories = [][][]uint32{combos}
+	} else {
+		pz := pointcloud.PanZoom{}
+		if viewport != nil {
+			pz = viewport.Pose
+		}
+		mx := mainLHeader.MinX + (mainLHeader.MaxX-mainLHeader.MinX)/2
+		my := mainLHeader.MinY + (mainLHeader.MaxY-mainLHeader.MinY)/2
+		mz := mainLHeader.MinZ + (mainLHeader.MaxZ-mainLHeader.MinZ)/2
+		dst := math.Sqrt(math.Pow(float64(pz.XPos-mx), 2) + math.Pow(float64(pz.YPos-my), 2) + math.Pow(float64(pz.ZPos-mz), 2))
+		// Frame is out of range
+		if dst/l.Policy.DistanceStep >= float64(len(categories)) {
+			return nil
+		}
+		dstID = int(dst / l.Policy.DistanceStep)
+		if viewport != nil {
+			r := math.Sqrt(math.Pow(float64(mainLHeader.MaxX-mx), 2) + math.Pow(float64(mainLHeader.MaxY-my), 2) + math.Pow(float64(mainLHeader.MaxZ-mz), 2))
+			if !viewport.IntersectsSphere([3]float64{float64(mx), float64(my), float64(mz)}, r) {
+				dstID = len(categories) - 1
+			}
+		}
+	}
+
+	budget := int(bitrate / 8 / l.FrameRate)
+	combo, size := l.selectCombination(categories[dstID:], layerData, mainSize, budget, selection)
+	// Not enough bitrate for any version
+	if combo == nil {
+		return nil
+	}
+	// Layers keep their ID order
+	selected := make([]uint32, 0, len(combo))
+	for _, id := range ids {
+		for _, c := range combo {
+			if c == id {
+				selected = append(selected, id)
+			}
+		}
+	}
+	return cache.get(selected, func() []byte {
+		mainLHeader.NLayers = uint32(len(selected))
+		buf := bytes.NewBuffer(make([]byte, 0, size))
+		if err := binary.Write(buf, binary.LittleEndian, mainLHeader); err != nil {
+			return nil
+		}
+		for _, id := range selected {
+			buf.Write(layerData[id])
+		}
+		return buf.Bytes()
+	})
+}
@@ -0,0 +1,185 @@
+package layered
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+const (
+	testMainSize  = int(unsafe.Sizeof(MultiLayerMainHeader{}))
+	testSideSize  = int(unsafe.Sizeof(MultiLayerSideHeader{}))
+	testLayerData = 100
+	testLayerSize = testSideSize + testLayerData
+)
+
+// testFrame builds a multi-layer frame centered at (distance, 0, 0) with a layer of
+// testLayerData bytes for every ID
+func testFrame(distance float32, ids ...uint32) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, MultiLayerMainHeader{
+		NLayers: uint32(len(ids)),
+		MinX:    distance - 1,
+		MinY:    -1,
+		MinZ:    -1,
+		MaxX:    distance + 1,
+		MaxY:    1,
+		MaxZ:    1,
+	})
+	for _, id := range ids {
+		binary.Write(buf, binary.LittleEndian, MultiLayerSideHeader{LayerID: id, FrameLen: testLayerData})
+		buf.Write(bytes.Repeat([]byte{byte(id)}, testLayerData))
+	}
+	return buf.Bytes()
+}
+
+// testBitrate is the bitrate that gives a budget of size bytes at one frame per second
+func testBitrate(size int) uint32 {
+	return uint32(size * 8)
+}
+
+func TestEncodeMultiFrame(t *testing.T) {
+	prefix := DefaultLayerPolicy()
+	prefix.Categories = nil
+	ample := testBitrate(1 << 20)
+
+	tests := []struct {
+		name    string
+		policy  LayerPolicy
+		frame   []byte
+		bitrate uint32
+		// Layer IDs of the encoded frame, nil when the frame is not sent
+		want []uint32
+	}{
+		{"one layer", prefix, testFrame(0, 0), ample, []uint32{0}},
+		{"three layers", prefix, testFrame(0, 0, 1, 2), ample, []uint32{0, 1, 2}},
+		{"five layers", prefix, testFrame(0, 0, 1, 2, 3, 4), ample, []uint32{0, 1, 2, 3, 4}},
+		{"prefix that fits", prefix, testFrame(0, 0, 1, 2), testBitrate(testMainSize + 2*testLayerSize), []uint32{0, 1}},
+		{"prefix ignores distance", prefix, testFrame(1000, 0, 1), ample, []uint32{0, 1}},
+		{"zero bitrate", prefix, testFrame(0, 0, 1, 2), 0, nil},
+		{"budget below layer 0", prefix, testFrame(0, 0, 1, 2), testBitrate(testMainSize + testLayerSize - 1), nil},
+		{"budget of exactly layer 0", prefix, testFrame(0, 0, 1, 2), testBitrate(testMainSize + testLayerSize), []uint32{0}},
+
+		{"near", DefaultLayerPolicy(), testFrame(0, 0, 1, 2), ample, []uint32{0, 1, 2}},
+		{"near with two layers of budget", DefaultLayerPolicy(), testFrame(0, 0, 1, 2), testBitrate(testMainSize + 2*testLayerSize), []uint32{0, 1}},
+		{"middle category", DefaultLayerPolicy(), testFrame(60, 0, 1, 2), ample, []uint32{1, 2}},
+		{"far category", DefaultLayerPolicy(), testFrame(120, 0, 1, 2), ample, []uint32{2}},
+		{"out of range", DefaultLayerPolicy(), testFrame(150, 0, 1, 2), ample, nil},
+		{"falls back to a further category", DefaultLayerPolicy(), testFrame(0, 1, 2), ample, []uint32{1, 2}},
+		{"combos with missing layers are skipped", DefaultLayerPolicy(), testFrame(0, 0, 2), ample, []uint32{0, 2}},
+		{"no combo with the frame's layers", DefaultLayerPolicy(), testFrame(0, 3), ample, nil},
+		{"zero bitrate with categories", DefaultLayerPolicy(), testFrame(0, 0, 1, 2), 0, nil},
+		{"no layers", prefix, testFrame(0), ample, nil},
+		{"duplicate layer", prefix, testFrame(0, 0, 0), ample, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLayeredEncoder(1, tt.policy)
+			encoded := l.EncodeMultiFrame(tt.frame, tt.bitrate, nil, nil, nil)
+			if tt.want == nil {
+				if encoded != nil {
+					t.Fatalf("encoded %d bytes, want nil", len(encoded))
+				}
+				return
+			}
+			if encoded == nil {
+				t.Fatalf("encoded nil, want layers %v", tt.want)
+			}
+			if len(encoded) != testMainSize+len(tt.want)*testLayerSize {
+				t.Errorf("encoded %d bytes, want %d", len(encoded), testMainSize+len(tt.want)*testLayerSize)
+			}
+			layers, err := ParseLayers(encoded)
+			if err != nil {
+				t.Fatalf("encoded frame: %v", err)
+			}
+			ids := make([]uint32, len(layers))
+			for i, layer := range layers {
+				ids[i] = layer.ID
+				data := encoded[layer.Offset+layer.Len-testLayerData : layer.Offset+layer.Len]
+				if !bytes.Equal(data, bytes.Repeat([]byte{byte(layer.ID)}, testLayerData)) {
+					t.Errorf("data of layer %d was not copied", layer.ID)
+				}
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("layers = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeMultiFrameUpgrade(t *testing.T) {
+	policy := DefaultLayerPolicy()
+	policy.Categories = nil
+	policy.UpgradeFrames = 3
+	l := NewLayeredEncoder(1, policy)
+	selection := NewLayerSelection()
+	frame := testFrame(0, 0, 1)
+
+	steps := []struct {
+		bitrate uint32
+		want    int
+	}{
+		{testBitrate(testMainSize + testLayerSize), 1},
+		// Both layers fit with the margin, the upgrade waits for UpgradeFrames frames
+		{testBitrate(2 * (testMainSize + 2*testLayerSize)), 1},
+		{testBitrate(2 * (testMainSize + 2*testLayerSize)), 1},
+		{testBitrate(2 * (testMainSize + 2*testLayerSize)), 2},
+		// Downgrades are immediate
+		{testBitrate(testMainSize + testLayerSize), 1},
+	}
+	for i, step := range steps {
+		encoded := l.EncodeMultiFrame(frame, step.bitrate, nil, selection, nil)
+		layers, err := ParseLayers(encoded)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if len(layers) != step.want {
+			t.Errorf("frame %d has %d layers, want %d", i, len(layers), step.want)
+		}
+	}
+}
+
+func TestParseLayers(t *testing.T) {
+	valid := testFrame(0, 0, 1)
+	tiled := make([]byte, unsafe.Sizeof(TiledMainHeader{}))
+	binary.LittleEndian.PutUint32(tiled, TiledFrameMagic)
+
+	tests := []struct {
+		name    string
+		frame   []byte
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"no layers", testFrame(0), false},
+		{"empty", nil, true},
+		{"truncated main header", valid[:testMainSize-1], true},
+		{"missing side header", valid[:testMainSize], true},
+		{"truncated side header", valid[:testMainSize+testSideSize-1], true},
+		{"truncated layer data", valid[:testMainSize+testLayerSize-1], true},
+		{"truncated second layer", valid[:len(valid)-1], true},
+		{"tiled frame", tiled, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers, err := ParseLayers(tt.frame)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			offset := 0
+			for i, layer := range layers {
+				if int(layer.Offset) != offset {
+					t.Errorf("layer %d starts at %d, want %d", i, layer.Offset, offset)
+				}
+				offset += int(layer.Len)
+			}
+			if offset != len(tt.frame) && len(layers) > 0 {
+				t.Errorf("layers cover %d of %d bytes", offset, len(tt.frame))
+			}
+		})
+	}
+}
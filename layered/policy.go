@@ -0,0 +1,97 @@
+package layered
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// LayerPolicy decides which layers of a multi-layer frame are sent at which distance from the viewer
+type LayerPolicy struct {
+	// Width of a distance category in scene units
+	DistanceStep float64 `json:"distance_step"`
+	// Categories[i] lists the layer combinations allowed in distance category i. A frame in
+	// category i can be sent with the combinations of category i and all further categories,
+	// frames further away than the last category are not sent. Without categories every prefix
+	// of the layers of the frame (base layer first) is a combination, independent of the distance.
+	Categories [][][]uint32 `json:"categories"`
+	// Quality of every layer by ID, the quality of a combination is the sum of its layers. Layers
+	// without an entry have the quality of the last entry, or 1 without entries.
+	Utilities []float64 `json:"utilities"`
+	// Fraction of the budget that has to be left over before a client gets a higher quality
+	UpgradeMargin float64 `json:"upgrade_margin"`
+	// Consecutive frames a higher quality has to fit before a client gets it
+	UpgradeFrames int `json:"upgrade_frames"`
+}
+
+// DefaultLayerPolicy is the policy for frames with three layers the encoder was built for
+func DefaultLayerPolicy() LayerPolicy {
+	return LayerPolicy{
+		DistanceStep: 50,
+		Categories: [][][]uint32{
+			{
+				{0},
+				{0, 2},
+				{0, 1},
+				{0, 1, 2},
+			},
+			{
+				{1},
+				{1, 2},
+			},
+			{
+				{2},
+			},
+		},
+		Utilities:     []float64{1, 0.5, 0.25},
+		UpgradeMargin: 0.1,
+		UpgradeFrames: 5,
+	}
+}
+
+// Utility returns the quality of a layer
+func (p *LayerPolicy) Utility(id uint32) float64 {
+	if len(p.Utilities) == 0 {
+		return 1
+	}
+	if int(id) >= len(p.Utilities) {
+		return p.Utilities[len(p.Utilities)-1]
+	}
+	return p.Utilities[id]
+}
+
+func (p *LayerPolicy) Validate() error {
+	var errs []error
+	if p.DistanceStep <= 0 {
+		errs = append(errs, errors.New("distance_step must be positive"))
+	}
+	for i, category := range p.Categories {
+		if len(category) == 0 {
+			errs = append(errs, fmt.Errorf("category %d has no layer combinations", i))
+		}
+		for j, combination := range category {
+			if len(combination) == 0 {
+				errs = append(errs, fmt.Errorf("combination %d of category %d is empty", j, i))
+			}
+			seen := make(map[uint32]bool)
+			for _, id := range combination {
+				if seen[id] {
+					errs = append(errs, fmt.Errorf("combination %d of category %d contains layer %d twice", j, i, id))
+				}
+				seen[id] = true
+			}
+		}
+	}
+	for i, u := range p.Utilities {
+		if u < 0 || math.IsNaN(u) || math.IsInf(u, 0) {
+			errs = append(errs, fmt.Errorf("utility of layer %d must be a non-negative number", i))
+		}
+	}
+	if p.UpgradeMargin < 0 || p.UpgradeMargin >= 1 {
+		errs = append(errs, errors.New("upgrade_margin must be in [0, 1)"))
+	}
+	if p.UpgradeFrames < 0 {
+		errs = append(errs, errors.New("upgrade_frames must not be negative"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,88 @@
+package layered
+
+// LayerSelection remembers the quality a client was sent last, so its quality only goes up once
+// the higher quality has fit the budget with a margin for a number of frames. Lower qualities are
+// taken right away. It is not safe for concurrent use.
+type LayerSelection struct {
+	hasQuality bool
+	quality    float64
+	// Consecutive frames a higher quality fit with the margin
+	upgradeFrames int
+}
+
+func NewLayerSelection() *LayerSelection {
+	return &LayerSelection{}
+}
+
+type candidate struct {
+	combo   []uint32
+	size    int
+	quality float64
+}
+
+// better returns whether c has a higher quality than other, or the same quality in fewer bytes
+func (c *candidate) better(other *candidate) bool {
+	if other == nil {
+		return true
+	}
+	if c.quality != other.quality {
+		return c.quality > other.quality
+	}
+	return c.size < other.size
+}
+
+// selectCombination returns the combination of categories with the highest quality that fits the
+// budget and its size including the main header, or nil when none fits
+func (l *LayeredEncoder) selectCombination(categories [][][]uint32, layerData map[uint32][]byte, mainSize int, budget int, selection *LayerSelection) ([]uint32, int) {
+	var best, held *candidate
+	for _, category := range categories {
+		for _, combo := range category {
+			c := &candidate{combo: combo, size: mainSize}
+			complete := true
+			for _, id := range combo {
+				data, ok := layerData[id]
+				if !ok {
+					complete = false
+					break
+				}
+				c.size += len(data)
+				c.quality += l.Policy.Utility(id)
+			}
+			if !complete || c.size > budget {
+				continue
+			}
+			if c.better(best) {
+				best = c
+			}
+			// Best combination that does not raise the quality of the client
+			if selection != nil && selection.hasQuality && c.quality <= selection.quality && c.better(held) {
+				held = c
+			}
+		}
+	}
+	if best == nil {
+		if selection != nil {
+			selection.upgradeFrames = 0
+		}
+		return nil, 0
+	}
+	if selection == nil {
+		return best.combo, best.size
+	}
+	if selection.hasQuality && best.quality > selection.quality && held != nil {
+		fits := float64(best.size) <= float64(budget)*(1-l.Policy.UpgradeMargin)
+		if fits {
+			selection.upgradeFrames++
+		} else {
+			selection.upgradeFrames = 0
+		}
+		if !fits || selection.upgradeFrames < l.Policy.UpgradeFrames {
+			selection.quality = held.quality
+			return held.combo, held.size
+		}
+	}
+	selection.hasQuality = true
+	selection.quality = best.quality
+	selection.upgradeFrames = 0
+	return best.combo, best.size
+}
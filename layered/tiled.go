@@ -0,0 +1,189 @@
+package layered
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+// TiledFrameMagic is the first field of a tiled frame, "TILE" in little endian. It takes the
+// place of NLayers of a multi-layer frame, which is never this large.
+const TiledFrameMagic uint32 = 0x454C4954
+
+// TiledMainHeader starts a tiled frame, it is followed by NTiles tiles
+type TiledMainHeader struct {
+	Magic  uint32
+	NTiles uint32
+}
+
+// TileHeader starts a tile, it is followed by NLayers layers that each start with a
+// MultiLayerSideHeader
+type TileHeader struct {
+	TileID  uint32
+	MinX    float32
+	MinY    float32
+	MinZ    float32
+	MaxX    float32
+	MaxY    float32
+	MaxZ    float32
+	NLayers uint32
+}
+
+// Tile is a parsed tile, the layers reference the frame it was parsed from
+type Tile struct {
+	Header TileHeader
+	// Side header and data of every layer, in frame order
+	Layers [][]byte
+	// ID of every layer
+	LayerIDs []uint32
+}
+
+// Center returns the center of the bounding box of the tile
+func (t *Tile) Center() [3]float64 {
+	h := t.Header
+	return [3]float64{float64(h.MinX+h.MaxX) / 2, float64(h.MinY+h.MaxY) / 2, float64(h.MinZ+h.MaxZ) / 2}
+}
+
+// Radius returns the radius of the sphere around the bounding box of the tile
+func (t *Tile) Radius() float64 {
+	h := t.Header
+	dx, dy, dz := float64(h.MaxX-h.MinX)/2, float64(h.MaxY-h.MinY)/2, float64(h.MaxZ-h.MinZ)/2
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// IsTiledFrame returns whether a frame starts with the tiled main header
+func IsTiledFrame(frame []byte) bool {
+	return len(frame) >= int(unsafe.Sizeof(TiledMainHeader{})) && binary.LittleEndian.Uint32(frame) == TiledFrameMagic
+}
+
+// ParseTiles parses the tiles of a tiled frame
+func ParseTiles(frame []byte) ([]*Tile, error) {
+	if !IsTiledFrame(frame) {
+		return nil, errors.New("frame is not a tiled frame")
+	}
+	r := bytes.NewReader(frame)
+	var mainHeader TiledMainHeader
+	if err := binary.Read(r, binary.LittleEndian, &mainHeader); err != nil {
+		return nil, err
+	}
+	tiles := make([]*Tile, 0)
+	for i := 0; i < int(mainHeader.NTiles); i++ {
+		tile := &Tile{}
+		if err := binary.Read(r, binary.LittleEndian, &tile.Header); err != nil {
+			return nil, fmt.Errorf("header of tile %d does not fit: %w", i, err)
+		}
+		for j := 0; j < int(tile.Header.NLayers); j++ {
+			offset := len(frame) - r.Len()
+			var sideHeader MultiLayerSideHeader
+			if err := binary.Read(r, binary.LittleEndian, &sideHeader); err != nil {
+				return nil, fmt.Errorf("side header of layer %d of tile %d does not fit: %w", j, tile.Header.TileID, err)
+			}
+			if int(sideHeader.FrameLen) > r.Len() {
+				return nil, fmt.Errorf("layer %d of tile %d does not fit", sideHeader.LayerID, tile.Header.TileID)
+			}
+			end := offset + int(unsafe.Sizeof(sideHeader)) + int(sideHeader.FrameLen)
+			tile.Layers = append(tile.Layers, frame[offset:end])
+			tile.LayerIDs = append(tile.LayerIDs, sideHeader.LayerID)
+			r.Seek(int64(end), 0)
+		}
+		tiles = append(tiles, tile)
+	}
+	return tiles, nil
+}
+
+// Encode selects the tiles and layers of a tiled or multi-layer frame for a viewer, the selection
+// is only used for multi-layer frames. Frames in the cache are shared and must not be modified.
+func (l *LayeredEncoder) Encode(frame []byte, bitrate uint32, viewport *pointcloud.Viewport, selection *LayerSelection, cache *EncodeCache) []byte {
+	if IsTiledFrame(frame) {
+		return l.EncodeTiledFrame(frame, bitrate, viewport, cache)
+	}
+	return l.EncodeMultiFrame(frame, bitrate, viewport, selection, cache)
+}
+
+type tileSelection struct {
+	tile     *Tile
+	distance float64
+	nLayers  int
+}
+
+// EncodeTiledFrame drops the tiles outside the view frustum and divides the per frame budget over
+// the remaining tiles. Every visible tile gets its first layer before any tile gets a second one,
+// within a round the tiles closest to the viewer go first. Without a viewport no tile is culled
+// and the viewer is at the origin. Clients that select the same tiles and layers share the frame in
+// the cache, which can be nil.
+func (l *LayeredEncoder) EncodeTiledFrame(frame []byte, bitrate uint32, viewport *pointcloud.Viewport, cache *EncodeCache) []byte {
+	tiles, err := ParseTiles(frame)
+	if err != nil {
+		return nil
+	}
+	pz := pointcloud.PanZoom{}
+	if viewport != nil {
+		pz = viewport.Pose
+	}
+	selections := make([]*tileSelection, 0, len(tiles))
+	for _, tile := range tiles {
+		center := tile.Center()
+		if viewport != nil && !viewport.IntersectsSphere(center, tile.Radius()) {
+			continue
+		}
+		dx, dy, dz := center[0]-float64(pz.XPos), center[1]-float64(pz.YPos), center[2]-float64(pz.ZPos)
+		selections = append(selections, &tileSelection{tile: tile, distance: math.Sqrt(dx*dx + dy*dy + dz*dz)})
+	}
+	sort.SliceStable(selections, func(i, j int) bool { return selections[i].distance < selections[j].distance })
+
+	budget := int(bitrate / 8 / l.FrameRate)
+	tileHeaderSize := int(unsafe.Sizeof(TileHeader{}))
+	size := int(unsafe.Sizeof(TiledMainHeader{}))
+	for added := true; added; {
+		added = false
+		for _, s := range selections {
+			if s.nLayers == len(s.tile.Layers) {
+				continue
+			}
+			cost := len(s.tile.Layers[s.nLayers])
+			if s.nLayers == 0 {
+				cost += tileHeaderSize
+			}
+			if size+cost > budget {
+				continue
+			}
+			size += cost
+			s.nLayers++
+			added = true
+		}
+	}
+
+	// Tile index and number of layers of every selected tile, in frame order
+	key := make([]uint32, 0)
+	for i, tile := range tiles {
+		for _, s := range selections {
+			if s.tile == tile && s.nLayers > 0 {
+				key = append(key, uint32(i), uint32(s.nLayers))
+			}
+		}
+	}
+	// Not enough bitrate for any tile or no tile is visible
+	if len(key) == 0 {
+		return nil
+	}
+	return cache.get(key, func() []byte {
+		buf := bytes.NewBuffer(make([]byte, 0, size))
+		binary.Write(buf, binary.LittleEndian, TiledMainHeader{Magic: TiledFrameMagic, NTiles: uint32(len(key) / 2)})
+		for i := 0; i < len(key); i += 2 {
+			tile := tiles[key[i]]
+			header := tile.Header
+			header.NLayers = key[i+1]
+			binary.Write(buf, binary.LittleEndian, header)
+			for _, layer := range tile.Layers[:key[i+1]] {
+				buf.Write(layer)
+			}
+		}
+		return buf.Bytes()
+	})
+}
@@ -0,0 +1,246 @@
+// Package metrics writes per-frame statistics to CSV files.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type FrameResult struct {
+	FrameNr uint32
+	// Index of the layer track a received frame arrived on, 0 for sent frames and single track clients
+	Layer                       uint32
+	SizeInBytes                 uint32
+	EntryTimestamp              int64
+	ProcessingCompleteTimestamp int64
+	Quality                     uint32
+	EstimatedBitrate            uint32
+	IsSender                    bool
+	// Retransmissions sent on the RTX stream so far, they are not part of SizeInBytes
+	RTXPackets uint64
+	RTXBytes   uint64
+	// Frames dropped by the send queue so far because they were late or superseded, and frames
+	// of which only the base layer was sent
+	DroppedLate       uint64
+	DroppedSuperseded uint64
+	BaseLayerOnly     uint64
+	// Bytes of the frame that were received, duplicated fragments and whether the frame was
+	// completed before its deadline, only set for received frames
+	ReceivedBytes uint32
+	Duplicates    uint32
+	Complete      bool
+}
+
+func NewFrameResult(frameNr uint32, layer uint32, entryTimestamp int64, isSender bool) *FrameResult {
+	return &FrameResult{
+		FrameNr:        frameNr,
+		Layer:          layer,
+		EntryTimestamp: entryTimestamp,
+		IsSender:       isSender,
+	}
+}
+
+// recordKey identifies a record, the parts of a frame that are received on separate layer tracks
+// have the same frame number
+type recordKey struct {
+	frameNr uint32
+	layer   uint32
+}
+
+type FrameResultWriter struct {
+	mtx          sync.Mutex
+	saveInterval uint32
+	isClosed     bool
+
+	receivedFrames map[recordKey]*FrameResult
+	sendFrames     map[recordKey]*FrameResult
+
+	receivedFramesFile   *os.File
+	sendFramesFile       *os.File
+	receivedFramesWriter *bufio.Writer
+	sendFramesWriter     *bufio.Writer
+}
+
+func NewFrameResultWriter(path string, saveInterval uint32) (*FrameResultWriter, error) {
+	fr := &FrameResultWriter{
+		saveInterval:   saveInterval,
+		receivedFrames: make(map[recordKey]*FrameResult),
+		sendFrames:     make(map[recordKey]*FrameResult),
+	}
+	recvFile, err := os.OpenFile(path+"recv.csv", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error creating resultwriter file: %w", err)
+	}
+	sendFile, err := os.OpenFile(path+"send.csv", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		recvFile.Close()
+		return nil, fmt.Errorf("error creating resultwriter file: %w", err)
+	}
+	fr.receivedFramesFile = recvFile
+	fr.sendFramesFile = sendFile
+	fr.receivedFramesWriter = bufio.NewWriter(recvFile)
+	fr.sendFramesWriter = bufio.NewWriter(sendFile)
+
+	fr.receivedFramesWriter.WriteString(fr.getHeader())
+	fr.sendFramesWriter.WriteString(fr.getHeader())
+	return fr, nil
+}
+
+func (fs *FrameResultWriter) CreateRecord(frameNr uint32, layer uint32, entryTimestamp int64, isSender bool) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	fr := NewFrameResult(frameNr, layer, entryTimestamp, isSender)
+	if isSender {
+		fs.sendFrames[recordKey{frameNr, layer}] = fr
+	} else {
+		fs.receivedFrames[recordKey{frameNr, layer}] = fr
+	}
+}
+
+func (fs *FrameResultWriter) SetSizeInBytes(frameNr uint32, layer uint32, sizeInBytes uint32, isSender bool) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if isSender {
+		if fr, ok := fs.sendFrames[recordKey{frameNr, layer}]; ok {
+			fr.SizeInBytes = sizeInBytes
+		} else {
+			//println("Setting size in bytes for unknown frame")
+		}
+	} else {
+		if fr, ok := fs.receivedFrames[recordKey{frameNr, layer}]; ok {
+			fr.SizeInBytes = sizeInBytes
+		} else {
+			//println("Setting size in bytes for unknown frame")
+		}
+	}
+}
+
+func (fs *FrameResultWriter) SetQuality(frameNr uint32, quality uint32) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if fr, ok := fs.sendFrames[recordKey{frameNr: frameNr}]; ok {
+		fr.Quality = quality
+	} else {
+		//println("Setting quality for unknown frame")
+	}
+}
+
+func (fs *FrameResultWriter) SetProcessingCompleteTimestamp(frameNr uint32, layer uint32, processingCompleteTimestamp int64, isSender bool) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if isSender {
+		if fr, ok := fs.sendFrames[recordKey{frameNr, layer}]; ok {
+			fr.ProcessingCompleteTimestamp = processingCompleteTimestamp
+		} else {
+			//println("Setting processing complete timestamps for unknown frame")
+		}
+	} else {
+		if fr, ok := fs.receivedFrames[recordKey{frameNr, layer}]; ok {
+			fr.ProcessingCompleteTimestamp = processingCompleteTimestamp
+		} else {
+			//println("Setting processing complete timestamps for unknown frame")
+		}
+	}
+}
+
+func (fs *FrameResultWriter) SetEstimatedBitrate(frameNr uint32, estimatedBitrate uint32) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if fr, ok := fs.sendFrames[recordKey{frameNr: frameNr}]; ok {
+		fr.EstimatedBitrate = estimatedBitrate
+	} else {
+		//println("Setting estimated bandwidth for unknown frame")
+	}
+}
+
+// SetRetransmissions records the retransmission counters of the connection when the frame was sent
+func (fs *FrameResultWriter) SetRetransmissions(frameNr uint32, packets uint64, bytes uint64) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if fr, ok := fs.sendFrames[recordKey{frameNr: frameNr}]; ok {
+		fr.RTXPackets = packets
+		fr.RTXBytes = bytes
+	}
+}
+
+// SetReception records how much of a received frame arrived
+func (fs *FrameResultWriter) SetReception(frameNr uint32, layer uint32, receivedBytes uint32, duplicates uint32, complete bool) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if fr, ok := fs.receivedFrames[recordKey{frameNr, layer}]; ok {
+		fr.ReceivedBytes = receivedBytes
+		fr.Duplicates = duplicates
+		fr.Complete = complete
+	}
+}
+
+// SetDroppedFrames records the send queue counters of the connection when the frame was sent
+func (fs *FrameResultWriter) SetDroppedFrames(frameNr uint32, late uint64, superseded uint64, baseLayerOnly uint64) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if fr, ok := fs.sendFrames[recordKey{frameNr: frameNr}]; ok {
+		fr.DroppedLate = late
+		fr.DroppedSuperseded = superseded
+		fr.BaseLayerOnly = baseLayerOnly
+	}
+}
+
+func (fs *FrameResultWriter) SaveRecord(frameNr uint32, layer uint32, isSender bool) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if isSender {
+		if fr, ok := fs.sendFrames[recordKey{frameNr, layer}]; ok {
+			if frameNr%fs.saveInterval == 0 {
+				fs.write(fs.sendFramesWriter, fr)
+			}
+			delete(fs.sendFrames, recordKey{frameNr, layer})
+		} else {
+			//println("SaveRecord unknown frame")
+		}
+	} else {
+		if fr, ok := fs.receivedFrames[recordKey{frameNr, layer}]; ok {
+			if frameNr%fs.saveInterval == 0 {
+				fs.write(fs.receivedFramesWriter, fr)
+			}
+			delete(fs.receivedFrames, recordKey{frameNr, layer})
+		} else {
+			//println("SaveRecord unknown frame")
+		}
+	}
+
+}
+
+// write must be called with mtx held, records arriving after Close are dropped
+func (fs *FrameResultWriter) write(w *bufio.Writer, fr *FrameResult) {
+	if fs.isClosed {
+		return
+	}
+	w.WriteString(fs.getRecord(fr))
+}
+
+// Close flushes the buffered records and closes both result files
+func (fs *FrameResultWriter) Close() error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if fs.isClosed {
+		return nil
+	}
+	fs.isClosed = true
+	return errors.Join(
+		fs.sendFramesWriter.Flush(),
+		fs.receivedFramesWriter.Flush(),
+		fs.sendFramesFile.Close(),
+		fs.receivedFramesFile.Close(),
+	)
+}
+
+func (fs *FrameResultWriter) getHeader() string {
+	return "frameNr;layer;sizeInBytes;eTimestamp;pTimestamp;quality;estimatedBitrate;isSender;rtxPackets;rtxBytes;receivedBytes;duplicates;complete;droppedLate;droppedSuperseded;baseLayerOnly;\n"
+}
+
+func (fs *FrameResultWriter) getRecord(fr *FrameResult) string {
+	return fmt.Sprintf("%d;%d;%d;%d;%d;%d;%d;%t;%d;%d;%d;%d;%t;%d;%d;%d\n", fr.FrameNr, fr.Layer, fr.SizeInBytes, fr.EntryTimestamp, fr.ProcessingCompleteTimestamp, fr.Quality, fr.EstimatedBitrate, fr.IsSender, fr.RTXPackets, fr.RTXBytes, fr.ReceivedBytes, fr.Duplicates, fr.Complete, fr.DroppedLate, fr.DroppedSuperseded, fr.BaseLayerOnly)
+}
@@ -0,0 +1,49 @@
+// Package pointcloud contains the types that are shared between the encoding,
+// transport and signaling packages.
+package pointcloud
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// Frame is a single encoded point cloud frame as it is handed to the transport
+type Frame struct {
+	ClientID uint32
+	FrameLen uint32
+	FrameNr  uint32
+	Data     []byte
+	// Time at which the frame was captured or, for stored content, played out. It is used
+	// for the RTP timestamps and is not part of Bytes.
+	CaptureTime time.Time
+	// Byte ranges of the layers in Data, nil when the frame is not a multi-layer frame
+	Layers []Layer
+}
+
+// Layer is the byte range of a single layer within a multi-layer frame
+type Layer struct {
+	ID     uint32
+	Offset uint32
+	Len    uint32
+}
+
+func (f *Frame) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, f.ClientID)
+	binary.Write(buf, binary.BigEndian, f.FrameLen)
+	binary.Write(buf, binary.BigEndian, f.FrameNr)
+	binary.Write(buf, binary.BigEndian, f.Data)
+	return buf.Bytes()
+}
+
+// PanZoom is the position and rotation of a viewer
+type PanZoom struct {
+	XPos float32 `json:"x_pos"`
+	YPos float32 `json:"y_pos"`
+	ZPos float32 `json:"z_pos"`
+
+	XRot float32 `json:"x_rot"`
+	YRot float32 `json:"y_rot"`
+	ZRot float32 `json:"z_rot"`
+}
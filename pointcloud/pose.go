@@ -0,0 +1,153 @@
+package pointcloud
+
+import (
+	"math"
+	"time"
+)
+
+// Longest time a pose is extrapolated, older velocities are not trusted beyond it
+const maxPosePrediction = 500 * time.Millisecond
+
+// PoseTracker smooths the poses reported by a viewer and predicts where the viewer will be when a
+// frame is displayed. Positions and velocities are smoothed exponentially, rotations are in degrees
+// and wrap around. It is not safe for concurrent use.
+type PoseTracker struct {
+	// Weight of a new sample, 1 disables smoothing
+	alpha float64
+
+	hasPose  bool
+	pose     [6]float64
+	velocity [6]float64
+	updated  time.Time
+}
+
+func NewPoseTracker(alpha float64) *PoseTracker {
+	return &PoseTracker{alpha: alpha}
+}
+
+// Update adds a pose that was reported at the given time
+func (t *PoseTracker) Update(pz PanZoom, at time.Time) {
+	sample := poseVector(pz)
+	if !t.hasPose {
+		t.pose = sample
+		t.updated = at
+		t.hasPose = true
+		return
+	}
+	dt := at.Sub(t.updated).Seconds()
+	previous := t.pose
+	for i := range t.pose {
+		t.pose[i] += t.alpha * poseDelta(i, sample[i], t.pose[i])
+		if i >= 3 {
+			t.pose[i] = wrapAngle(t.pose[i])
+		}
+	}
+	if dt > 0 {
+		for i := range t.velocity {
+			v := poseDelta(i, t.pose[i], previous[i]) / dt
+			t.velocity[i] += t.alpha * (v - t.velocity[i])
+		}
+	}
+	t.updated = at
+}
+
+// Smoothed returns the smoothed pose at the time of the last update
+func (t *PoseTracker) Smoothed() PanZoom {
+	return panZoom(t.pose)
+}
+
+// Predict extrapolates the smoothed pose to the given time
+func (t *PoseTracker) Predict(at time.Time) PanZoom {
+	horizon := at.Sub(t.updated)
+	if !t.hasPose || horizon <= 0 {
+		return panZoom(t.pose)
+	}
+	if horizon > maxPosePrediction {
+		horizon = maxPosePrediction
+	}
+	predicted := t.pose
+	for i := range predicted {
+		predicted[i] += t.velocity[i] * horizon.Seconds()
+		if i >= 3 {
+			predicted[i] = wrapAngle(predicted[i])
+		}
+	}
+	return panZoom(predicted)
+}
+
+// Forward returns the unit vector the viewer looks along, using the left-handed convention of
+// the clients: yaw (YRot) turns around the y axis and pitch (XRot) looks down for positive angles
+func (pz PanZoom) Forward() (x, y, z float64) {
+	pitch := float64(pz.XRot) * math.Pi / 180
+	yaw := float64(pz.YRot) * math.Pi / 180
+	return math.Sin(yaw) * math.Cos(pitch), -math.Sin(pitch), math.Cos(yaw) * math.Cos(pitch)
+}
+
+func poseVector(pz PanZoom) [6]float64 {
+	return [6]float64{float64(pz.XPos), float64(pz.YPos), float64(pz.ZPos), float64(pz.XRot), float64(pz.YRot), float64(pz.ZRot)}
+}
+
+func panZoom(v [6]float64) PanZoom {
+	return PanZoom{
+		XPos: float32(v[0]), YPos: float32(v[1]), ZPos: float32(v[2]),
+		XRot: float32(v[3]), YRot: float32(v[4]), ZRot: float32(v[5]),
+	}
+}
+
+// poseDelta is a - b, rotations (index 3 and up) take the shortest way around
+func poseDelta(i int, a, b float64) float64 {
+	if i < 3 {
+		return a - b
+	}
+	return wrapAngle(a - b)
+}
+
+// wrapAngle maps an angle in degrees to [-180, 180)
+func wrapAngle(a float64) float64 {
+	a = math.Mod(a+180, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a - 180
+}
+
+// Viewport is the predicted pose of a viewer and its field of view in degrees
+type Viewport struct {
+	Pose          PanZoom
+	HorizontalFOV float64
+	VerticalFOV   float64
+}
+
+// Axes returns the right, up and forward unit vectors of the viewer, roll is not taken into account
+func (pz PanZoom) Axes() (right, up, forward [3]float64) {
+	fx, fy, fz := pz.Forward()
+	yaw := float64(pz.YRot) * math.Pi / 180
+	right = [3]float64{math.Cos(yaw), 0, -math.Sin(yaw)}
+	forward = [3]float64{fx, fy, fz}
+	up = [3]float64{
+		forward[1]*right[2] - forward[2]*right[1],
+		forward[2]*right[0] - forward[0]*right[2],
+		forward[0]*right[1] - forward[1]*right[0],
+	}
+	return right, up, forward
+}
+
+// IntersectsSphere returns whether a sphere is at least partly inside the view frustum. The
+// frustum has no far plane and its near plane goes through the position of the viewer.
+func (v Viewport) IntersectsSphere(center [3]float64, radius float64) bool {
+	d := [3]float64{center[0] - float64(v.Pose.XPos), center[1] - float64(v.Pose.YPos), center[2] - float64(v.Pose.ZPos)}
+	right, up, forward := v.Pose.Axes()
+	x, y, z := dot(d, right), dot(d, up), dot(d, forward)
+	if z < -radius {
+		return false
+	}
+	h := v.HorizontalFOV / 2 * math.Pi / 180
+	vert := v.VerticalFOV / 2 * math.Pi / 180
+	// Signed distances to the side planes, positive outside
+	return math.Abs(x)*math.Cos(h)-z*math.Sin(h) <= radius &&
+		math.Abs(y)*math.Cos(vert)-z*math.Sin(vert) <= radius
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
@@ -1,4 +1,5 @@
-package main
+// Package proxy receives captured point cloud frames from the capture application over UDP.
+package proxy
 
 import (
 	"bytes"
@@ -6,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
 const (
@@ -13,6 +15,7 @@ const (
 	FramePacketType   uint32 = 1
 	AudioPacketType   uint32 = 2
 	ControlPacketType uint32 = 3
+	LeavePacketType   uint32 = 4
 )
 
 type RemoteInputPacketHeader struct {
@@ -28,6 +31,8 @@ type RemoteFrame struct {
 	currentLen uint32
 	frameLen   uint32
 	frameData  []byte
+	// Arrival of the first fragment, the capture application does not send capture timestamps
+	captureTime time.Time
 }
 
 type ProxyConnection struct {
@@ -43,10 +48,20 @@ type ProxyConnection struct {
 
 	mtx_pccon  sync.Mutex
 	cond_video map[uint32]*sync.Cond
+	isClosed   bool
+
+	// Provides the estimated bitrate of every ready client
+	bitrates func() map[uint32]uint32
 }
 
-func NewProxyConnection(indi_mode bool) *ProxyConnection {
-	pc := &ProxyConnection{nil, nil, make(map[uint32]RemoteFrame), make([]RemoteFrame, 0), 0, indi_mode, sync.Mutex{}, make(map[uint32]*sync.Cond)}
+func NewProxyConnection(indi_mode bool, bitrates func() map[uint32]uint32) *ProxyConnection {
+	pc := &ProxyConnection{
+		incomplete_frames: make(map[uint32]RemoteFrame),
+		complete_frames:   make([]RemoteFrame, 0),
+		indi_mode:         indi_mode,
+		cond_video:        make(map[uint32]*sync.Cond),
+		bitrates:          bitrates,
+	}
 	//pc.cond_video = sync.NewCond(&pc.mtx_video)
 	if !indi_mode {
 		pc.cond_video[0] = sync.NewCond(&pc.mtx_pccon)
@@ -61,28 +76,24 @@ func (pc *ProxyConnection) sendPacket(b []byte, offset uint32, packet_type uint3
 	_, err := pc.conn.WriteToUDP(buffProxy, pc.addr)
 	if err != nil {
 		fmt.Println("Error sending response:", err)
-		panic(err)
 	}
 }
 
-func (pc *ProxyConnection) SetupConnection(capAddr string, srvAddr string) {
+func (pc *ProxyConnection) SetupConnection(capAddr string, srvAddr string) error {
 	address, err := net.ResolveUDPAddr("udp", srvAddr)
 	if err != nil {
-		fmt.Printf("WebRTCPeer: ERROR: %s\n", err)
-		return
+		return fmt.Errorf("WebRTCPeer: %w", err)
 	}
 
 	// Create a UDP connection
 	pc.conn, err = net.ListenUDP("udp", address)
 	if err != nil {
-		fmt.Printf("WebRTCPeer: ERROR: %s\n", err)
-		return
+		return fmt.Errorf("WebRTCPeer: %w", err)
 	}
 
 	pc.addr, err = net.ResolveUDPAddr("udp", capAddr)
 	if err != nil {
-		fmt.Printf("WebRTCPeer: ERROR: %s\n", err)
-		return
+		return fmt.Errorf("WebRTCPeer: %w", err)
 	}
 
 	pc.SendPeerReadyPacket()
@@ -92,11 +103,11 @@ func (pc *ProxyConnection) SetupConnection(capAddr string, srvAddr string) {
 	fmt.Println("WebRTCPeer: Waiting for a message...", srvAddr, pc.addr.IP.String())
 	_, pc.addr, err = pc.conn.ReadFromUDP(buffer)
 	if err != nil {
-		fmt.Printf("WebRTCPeer: ERROR: %s\n", err)
-		return
+		return fmt.Errorf("WebRTCPeer: %w", err)
 	}
 	fmt.Println("WebRTCPeer: Connected to Unity DLL")
 	pc.StartListening()
+	return nil
 }
 
 func (pc *ProxyConnection) StartListening() {
@@ -104,7 +115,16 @@ func (pc *ProxyConnection) StartListening() {
 	go func() {
 		for {
 			buffer := make([]byte, 1500)
-			_, _, _ = pc.conn.ReadFromUDP(buffer)
+			if _, _, err := pc.conn.ReadFromUDP(buffer); err != nil {
+				pc.mtx_pccon.Lock()
+				isClosed := pc.isClosed
+				pc.mtx_pccon.Unlock()
+				if isClosed {
+					return
+				}
+				fmt.Println("Error Proxy:", err)
+				continue
+			}
 			var packetType uint32
 			err := binary.Read(bytes.NewReader(buffer[:4]), binary.LittleEndian, &packetType)
 			bufBinary := bytes.NewBuffer(buffer[4:24])
@@ -124,6 +144,7 @@ func (pc *ProxyConnection) StartListening() {
 						0,
 						p.Framelen,
 						make([]byte, p.Framelen),
+						time.Now(),
 					}
 					pc.incomplete_frames[p.Framenr] = r
 				}
@@ -139,7 +160,9 @@ func (pc *ProxyConnection) StartListening() {
 					}
 					pc.complete_frames = append(pc.complete_frames, value)
 					delete(pc.incomplete_frames, p.Framenr)
-					pc.cond_video[p.ClientID].Broadcast()
+					if cond, ok := pc.cond_video[p.ClientID]; ok {
+						cond.Broadcast()
+					}
 				}
 				//println(p.Frameoffset, p.Framenr, value.currentLen, p.Framelen)
 				pc.mtx_pccon.Unlock()
@@ -157,33 +180,47 @@ func (pc *ProxyConnection) SendPeerReadyPacket() {
 	pc.sendPacket(make([]byte, 100), 0, ReadyPacketType)
 }
 
-func (pc *ProxyConnection) SendBitrates() bool {
-	pcMapMutex.Lock()
-	defer pcMapMutex.Unlock()
-	nClients := uint64(len(peerConnections))
-	for _, peerConn := range peerConnections {
-		if !peerConn.isReady {
-			nClients--
-		}
+// SendLeavePacket tells the capture application that the server is going away
+func (pc *ProxyConnection) SendLeavePacket() {
+	pc.sendPacket(make([]byte, 100), 0, LeavePacketType)
+}
+
+// Close says goodbye to the capture application, stops listening and wakes up
+// everyone waiting in NextFrame
+func (pc *ProxyConnection) Close() error {
+	pc.mtx_pccon.Lock()
+	if pc.isClosed {
+		pc.mtx_pccon.Unlock()
+		return nil
+	}
+	pc.isClosed = true
+	for _, cond := range pc.cond_video {
+		cond.Broadcast()
 	}
+	pc.mtx_pccon.Unlock()
+	if pc.conn == nil {
+		return nil
+	}
+	pc.SendLeavePacket()
+	return pc.conn.Close()
+}
 
+func (pc *ProxyConnection) SendBitrates() bool {
+	bitrates := pc.bitrates()
 	buffer := new(bytes.Buffer)
-	err := binary.Write(buffer, binary.LittleEndian, uint32(nClients))
+	err := binary.Write(buffer, binary.LittleEndian, uint32(len(bitrates)))
 	if err != nil {
 		return false
 	}
-	for key, peerConn := range peerConnections {
+	for key, bitrate := range bitrates {
 		// Write the key to the buffer
-		if !peerConn.isReady {
-			continue
-		}
-		err := binary.Write(buffer, binary.LittleEndian, uint32(key))
+		err := binary.Write(buffer, binary.LittleEndian, key)
 		if err != nil {
 			return false
 		}
 
 		// Write the PeerConnection.GetBitrate() to the buffer
-		err = binary.Write(buffer, binary.LittleEndian, uint32(peerConn.GetBitrate()))
+		err = binary.Write(buffer, binary.LittleEndian, bitrate)
 		if err != nil {
 			return false
 		}
@@ -193,27 +230,27 @@ func (pc *ProxyConnection) SendBitrates() bool {
 	return true
 }
 
-func (pc *ProxyConnection) NextFrame(clientID uint32) (uint32, []byte) {
-	isNextFrameReady := false
-
-	for !isNextFrameReady {
-		pc.mtx_pccon.Lock()
-		if len(pc.complete_frames) > 0 {
-			isNextFrameReady = true
-		} else {
-			pc.cond_video[clientID].Wait()
-			isNextFrameReady = true
+// NextFrame blocks until a frame is available and returns it with its capture time, it returns nil data when the client
+// disconnected while waiting
+func (pc *ProxyConnection) NextFrame(clientID uint32) (uint32, []byte, time.Time) {
+	pc.mtx_pccon.Lock()
+	defer pc.mtx_pccon.Unlock()
+	for len(pc.complete_frames) == 0 {
+		cond, ok := pc.cond_video[clientID]
+		if !ok || pc.isClosed {
+			return 0, nil, time.Time{}
 		}
+		cond.Wait()
 	}
 	data := pc.complete_frames[0].frameData
 	frameNr := pc.complete_frames[0].frameNr
+	captureTime := pc.complete_frames[0].captureTime
 	if pc.frameCounter%100 == 0 {
 		println("SENDING FRAME ", pc.frameCounter)
 	}
 	pc.complete_frames = pc.complete_frames[1:]
 	pc.frameCounter = pc.frameCounter + 1
-	pc.mtx_pccon.Unlock()
-	return frameNr, data
+	return frameNr, data, captureTime
 }
 
 func (pc *ProxyConnection) OnNewClientConnected(clientID uint32) {
@@ -230,5 +267,9 @@ func (pc *ProxyConnection) OnNewClientDisconnected(clientID uint32) {
 	}
 	pc.mtx_pccon.Lock()
 	defer pc.mtx_pccon.Unlock()
-	delete(pc.cond_video, clientID)
+	// Wake up the sender of this client so it can notice it is gone
+	if cond, ok := pc.cond_video[clientID]; ok {
+		delete(pc.cond_video, clientID)
+		cond.Broadcast()
+	}
 }
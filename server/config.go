@@ -0,0 +1,455 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/layered"
+	"github.com/MatthiasDeFre/webrtc-pc-server/transport"
+)
+
+// Duration is a time.Duration that is written as "5s" / "10ms" in configuration files
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// FrameTransport selects how frames are sent to the clients
+type FrameTransport string
+
+const (
+	// Frames are sent on the point cloud RTP track, used by the native clients
+	TransportRTP FrameTransport = "rtp"
+	// Frames are sent on a data channel, used by browser clients that cannot consume the RTP payload
+	TransportDataChannel FrameTransport = "datachannel"
+)
+
+// maxLayerStreams limits the number of point cloud tracks of a client
+const maxLayerStreams = 8
+
+// ServerConfig contains all options of a Server
+type ServerConfig struct {
+	SignalingAddr      string   `json:"signaling_addr"`
+	LegacySignaling    bool     `json:"legacy_signaling"`
+	WaitForClientOffer bool     `json:"wait_for_client_offer"`
+	VirtualWallIP      string   `json:"virtual_wall_ip"`
+	ShutdownTimeout    Duration `json:"shutdown_timeout"`
+	// Maximum number of simultaneous clients, 0 or less means unlimited
+	MaxClients int  `json:"max_clients"`
+	IsIndi     bool `json:"individual_encoding"`
+
+	UseProxy         bool   `json:"use_proxy"`
+	ProxyCaptureAddr string `json:"proxy_capture_addr"`
+	ProxyServerAddr  string `json:"proxy_server_addr"`
+	ContentDirectory string `json:"content_directory"`
+	ContentFrameRate uint32 `json:"content_frame_rate"`
+	// Directory of the result files of the clients, it is created on start. Servers in the same
+	// process need different directories since client IDs start at 0 for every server.
+	ResultDirectory string `json:"result_directory"`
+	// Layer combinations of multi-layer frames by distance to the viewer
+	LayerPolicy layered.LayerPolicy `json:"layer_policy"`
+
+	PeerConnection PeerConnectionConfig `json:"peer_connection"`
+}
+
+// PeerConnectionConfig contains the options of every client connection
+type PeerConnectionConfig struct {
+	// GCC bitrates in bits per second
+	MinBitrate     int `json:"min_bitrate"`
+	InitialBitrate int `json:"initial_bitrate"`
+	MaxBitrate     int `json:"max_bitrate"`
+
+	TWCCSendInterval         Duration `json:"twcc_send_interval"`
+	SCTPMaxReceiveBufferSize uint32   `json:"sctp_max_receive_buffer_size"`
+	DisconnectTimeout        Duration `json:"disconnect_timeout"`
+	// Interceptor sets of the WebRTC pipeline, empty means DefaultInterceptorSets
+	Interceptors       []InterceptorSet `json:"interceptors"`
+	RTCPReportInterval Duration         `json:"rtcp_report_interval"`
+
+	// Maximum size of an RTP packet including header and extensions, lower it on paths
+	// with VPN or tunnel overhead
+	MTU int `json:"mtu"`
+	// Bytes of frame data in every RTP packet, fragments are made smaller when the mtu requires it
+	FragmentSize int `json:"fragment_size"`
+	// Wire format of the fragments, 1 is compact, 0 is the legacy fixed size format
+	FramePacketVersion uint8 `json:"frame_packet_version"`
+	// XOR parity packets per fragment for every layer (base layer first), empty disables FEC
+	FECRatios []float64 `json:"fec_ratios"`
+	// Frame rate used to turn the estimated bitrate into a per frame budget
+	EncoderFrameRate uint32 `json:"encoder_frame_rate"`
+	// Number of received frames that are kept
+	ReceiveRingSize uint32 `json:"receive_ring_size"`
+	// Time after its first fragment at which an incomplete received frame is dropped
+	ReassemblyDeadline Duration `json:"reassembly_deadline"`
+	// Largest received frame, fragments of larger frames are rejected
+	MaxFrameSize uint32 `json:"max_frame_size"`
+	// Bytes that incomplete received frames of a track can take, the oldest frame is dropped first
+	ReassemblyBufferSize uint32 `json:"reassembly_buffer_size"`
+	// Number of encoded frames that can wait to be sent, the oldest is dropped when it is full
+	SendQueueSize int `json:"send_queue_size"`
+	// Time after its capture at which a frame is no longer sent
+	SendDeadline Duration `json:"send_deadline"`
+	// The pacer of the gcc interceptor set sends RTP packets at the estimated bitrate times the
+	// multiplier, 0 disables pacing. Up to pacing_burst bytes are sent without waiting.
+	PacingMultiplier float64 `json:"pacing_multiplier"`
+	PacingBurst      int     `json:"pacing_burst"`
+	// Weight of a new pose sample of the viewer (1 disables smoothing) and how far ahead the pose
+	// is predicted for layer selection (0 disables prediction)
+	PoseSmoothing  float64  `json:"pose_smoothing"`
+	PosePrediction Duration `json:"pose_prediction"`
+	// Field of view of the viewers in degrees, frames and tiles outside of it are culled
+	HorizontalFOV float64 `json:"horizontal_fov"`
+	VerticalFOV   float64 `json:"vertical_fov"`
+	// Number of RTP streams of the rtp transport, layer i of a multi-layer frame is sent on
+	// stream i and layers beyond the last stream share the last stream
+	LayerStreams int `json:"layer_streams"`
+	// Transport of outgoing frames, rtp or datachannel
+	Transport FrameTransport `json:"transport"`
+	// Delivery of the data channel transport, unordered channels can be made unreliable with
+	// either a retransmission limit (-1 is unlimited) or a packet lifetime (0 is unlimited)
+	DataChannelOrdered           bool     `json:"data_channel_ordered"`
+	DataChannelMaxRetransmits    int      `json:"data_channel_max_retransmits"`
+	DataChannelMaxPacketLifeTime Duration `json:"data_channel_max_packet_life_time"`
+	// Bytes of frame data in every data channel message
+	DataChannelFragmentSize int `json:"data_channel_fragment_size"`
+	// Only every n-th frame is written to the result files
+	ResultSaveInterval uint32 `json:"result_save_interval"`
+}
+
+// DefaultServerConfig returns the configuration that is used when nothing is overridden
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		SignalingAddr:    "0.0.0.0:5678",
+		ShutdownTimeout:  Duration(5 * time.Second),
+		MaxClients:       -1,
+		ProxyCaptureAddr: ":8000",
+		ProxyServerAddr:  ":8001",
+		ContentDirectory: "content_jpg",
+		ContentFrameRate: 30,
+		ResultDirectory:  ".",
+		LayerPolicy:      layered.DefaultLayerPolicy(),
+		PeerConnection: PeerConnectionConfig{
+			MinBitrate:                75_000 * 8,
+			InitialBitrate:            75_000_000,
+			MaxBitrate:                262_744_320,
+			TWCCSendInterval:          Duration(10 * time.Millisecond),
+			SCTPMaxReceiveBufferSize:  16 * 1024 * 1024,
+			DisconnectTimeout:         Duration(5 * time.Second),
+			Interceptors:              append([]InterceptorSet(nil), DefaultInterceptorSets...),
+			RTCPReportInterval:        Duration(time.Second),
+			MTU:                       transport.DefaultMTU,
+			FragmentSize:              1180,
+			FramePacketVersion:        transport.FramePacketVersion,
+			EncoderFrameRate:          30,
+			ReceiveRingSize:           100,
+			ReassemblyDeadline:        Duration(time.Second),
+			MaxFrameSize:              16 << 20,
+			ReassemblyBufferSize:      64 << 20,
+			SendQueueSize:             2,
+			SendDeadline:              Duration(150 * time.Millisecond),
+			PacingMultiplier:          2.5,
+			PacingBurst:               10 * transport.DefaultMTU,
+			PoseSmoothing:             0.5,
+			PosePrediction:            Duration(100 * time.Millisecond),
+			HorizontalFOV:             120,
+			VerticalFOV:               90,
+			LayerStreams:              1,
+			Transport:                 TransportRTP,
+			DataChannelOrdered:        true,
+			DataChannelMaxRetransmits: -1,
+			DataChannelFragmentSize:   transport.DefaultDataChannelFragmentSize,
+			ResultSaveInterval:        5,
+		},
+	}
+}
+
+// LoadConfigFile reads a JSON configuration file on top of the values already in config,
+// files with another extension (e.g. YAML or TOML) are rejected
+func LoadConfigFile(path string, config *ServerConfig) error {
+	if ext := filepath.Ext(path); !strings.EqualFold(ext, ".json") {
+		return fmt.Errorf("config %s: unsupported format %q, only .json files are supported", path, ext)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides the configuration with environment variables. The name of a variable
+// is the prefix followed by the upper-cased JSON path, e.g. PCSERVER_PEER_CONNECTION_MAX_BITRATE.
+func (c *ServerConfig) ApplyEnv(prefix string) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), prefix)
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(fv, key); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		if err := setFromString(fv, value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func setFromString(fv reflect.Value, value string) error {
+	if fv.Type() == reflect.TypeOf(Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Slice {
+			return errors.New("nested lists are only supported in configuration files")
+		}
+		// Lists are comma separated
+		items := strings.Split(value, ",")
+		slice := reflect.MakeSlice(fv.Type(), 0, len(items))
+		for _, item := range items {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			elem := reflect.New(fv.Type().Elem()).Elem()
+			if err := setFromString(elem, item); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		fv.Set(slice)
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// Validate checks the configuration for values the server cannot work with
+func (c *ServerConfig) Validate() error {
+	var errs []error
+	if c.SignalingAddr == "" {
+		errs = append(errs, errors.New("signaling_addr is empty"))
+	}
+	if c.UseProxy {
+		if c.ProxyCaptureAddr == "" || c.ProxyServerAddr == "" {
+			errs = append(errs, errors.New("proxy_capture_addr and proxy_server_addr are required when using the proxy"))
+		}
+	} else {
+		if c.IsIndi {
+			errs = append(errs, errors.New("individual_encoding requires use_proxy"))
+		}
+		if c.ContentDirectory == "" {
+			errs = append(errs, errors.New("content_directory is empty"))
+		}
+		if c.ContentFrameRate == 0 {
+			errs = append(errs, errors.New("content_frame_rate must be positive"))
+		}
+	}
+	if c.ResultDirectory == "" {
+		errs = append(errs, errors.New("result_directory is empty"))
+	} else if info, err := os.Stat(c.ResultDirectory); err == nil && !info.IsDir() {
+		errs = append(errs, fmt.Errorf("result_directory %s is not a directory", c.ResultDirectory))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if err := c.LayerPolicy.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("layer_policy: %w", err))
+	}
+	if err := c.PeerConnection.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *PeerConnectionConfig) Validate() error {
+	var errs []error
+	if c.MinBitrate <= 0 || c.MinBitrate > c.InitialBitrate || c.InitialBitrate > c.MaxBitrate {
+		errs = append(errs, fmt.Errorf("bitrates must satisfy 0 < min (%d) <= initial (%d) <= max (%d)", c.MinBitrate, c.InitialBitrate, c.MaxBitrate))
+	}
+	if c.TWCCSendInterval <= 0 {
+		errs = append(errs, errors.New("twcc_send_interval must be positive"))
+	}
+	if c.SCTPMaxReceiveBufferSize == 0 {
+		errs = append(errs, errors.New("sctp_max_receive_buffer_size must be positive"))
+	}
+	if c.DisconnectTimeout <= 0 {
+		errs = append(errs, errors.New("disconnect_timeout must be positive"))
+	}
+	if err := validateInterceptorSets(c.Interceptors); err != nil {
+		errs = append(errs, err)
+	}
+	if c.RTCPReportInterval <= 0 {
+		errs = append(errs, errors.New("rtcp_report_interval must be positive"))
+	}
+	if c.FragmentSize <= 0 || c.FragmentSize > transport.MaxFragmentSize {
+		errs = append(errs, fmt.Errorf("fragment_size must be between 1 and %d", transport.MaxFragmentSize))
+	}
+	if c.FramePacketVersion != transport.FramePacketVersionLegacy && c.FramePacketVersion != transport.FramePacketVersion {
+		errs = append(errs, fmt.Errorf("frame_packet_version must be %d or %d", transport.FramePacketVersionLegacy, transport.FramePacketVersion))
+	}
+	for _, ratio := range c.FECRatios {
+		if ratio < 0 || ratio > 1 {
+			errs = append(errs, fmt.Errorf("fec_ratios must be between 0 and 1, got %g", ratio))
+			break
+		}
+	}
+	if len(c.FECRatios) > 0 && c.FramePacketVersion == transport.FramePacketVersionLegacy {
+		errs = append(errs, errors.New("fec_ratios requires the compact frame_packet_version"))
+	}
+	minMTU := transport.RTPPacketOverhead + transport.FramePacketHeaderSize + 1
+	if c.FramePacketVersion == transport.FramePacketVersionLegacy {
+		// Legacy fragments have a fixed size and cannot be made smaller
+		minMTU = transport.RTPPacketOverhead + transport.LegacyFramePacketSize
+	}
+	if NewInterceptorPipeline(*c).Enabled(InterceptorRTX) {
+		// RTX packets carry the original sequence number in front of the payload
+		minMTU += transport.RTXOverhead
+	}
+	if c.MTU < minMTU || c.MTU > math.MaxUint16 {
+		errs = append(errs, fmt.Errorf("mtu must be between %d and %d", minMTU, math.MaxUint16))
+	}
+	if c.EncoderFrameRate == 0 {
+		errs = append(errs, errors.New("encoder_frame_rate must be positive"))
+	}
+	if c.ReceiveRingSize == 0 {
+		errs = append(errs, errors.New("receive_ring_size must be positive"))
+	}
+	if c.ReassemblyDeadline <= 0 {
+		errs = append(errs, errors.New("reassembly_deadline must be positive"))
+	}
+	if c.MaxFrameSize == 0 {
+		errs = append(errs, errors.New("max_frame_size must be positive"))
+	}
+	if c.ReassemblyBufferSize < c.MaxFrameSize {
+		errs = append(errs, errors.New("reassembly_buffer_size must be at least max_frame_size"))
+	}
+	if c.Transport != TransportRTP && c.Transport != TransportDataChannel {
+		errs = append(errs, fmt.Errorf("transport must be %q or %q", TransportRTP, TransportDataChannel))
+	}
+	if c.SendQueueSize <= 0 {
+		errs = append(errs, errors.New("send_queue_size must be positive"))
+	}
+	if c.SendDeadline <= 0 {
+		errs = append(errs, errors.New("send_deadline must be positive"))
+	}
+	if c.PacingMultiplier < 0 {
+		errs = append(errs, errors.New("pacing_multiplier must not be negative"))
+	}
+	if c.PacingMultiplier > 0 && c.PacingBurst <= 0 {
+		errs = append(errs, errors.New("pacing_burst must be positive"))
+	}
+	if c.PoseSmoothing <= 0 || c.PoseSmoothing > 1 {
+		errs = append(errs, errors.New("pose_smoothing must be in (0, 1]"))
+	}
+	if c.PosePrediction < 0 {
+		errs = append(errs, errors.New("pose_prediction must not be negative"))
+	}
+	if c.HorizontalFOV <= 0 || c.HorizontalFOV >= 180 || c.VerticalFOV <= 0 || c.VerticalFOV >= 180 {
+		errs = append(errs, errors.New("horizontal_fov and vertical_fov must be between 0 and 180 degrees"))
+	}
+	if c.LayerStreams < 1 || c.LayerStreams > maxLayerStreams {
+		errs = append(errs, fmt.Errorf("layer_streams must be between 1 and %d", maxLayerStreams))
+	}
+	if c.Transport == TransportDataChannel && c.LayerStreams > 1 {
+		errs = append(errs, fmt.Errorf("layer_streams requires the %q transport", TransportRTP))
+	}
+	if c.Transport == TransportDataChannel && len(c.FECRatios) > 0 {
+		errs = append(errs, fmt.Errorf("fec_ratios requires the %q transport", TransportRTP))
+	}
+	if c.DataChannelMaxRetransmits < -1 || c.DataChannelMaxRetransmits > math.MaxUint16 {
+		errs = append(errs, fmt.Errorf("data_channel_max_retransmits must be between -1 and %d", math.MaxUint16))
+	}
+	if c.DataChannelMaxPacketLifeTime < 0 || time.Duration(c.DataChannelMaxPacketLifeTime) > math.MaxUint16*time.Millisecond {
+		errs = append(errs, fmt.Errorf("data_channel_max_packet_life_time must be between 0 and %dms", math.MaxUint16))
+	}
+	if c.DataChannelMaxRetransmits >= 0 && c.DataChannelMaxPacketLifeTime > 0 {
+		errs = append(errs, errors.New("data_channel_max_retransmits and data_channel_max_packet_life_time cannot both be set"))
+	}
+	maxDataChannelFragmentSize := transport.MaxDataChannelMessageSize - transport.FramePacketHeaderSize
+	if c.DataChannelFragmentSize <= 0 || c.DataChannelFragmentSize > maxDataChannelFragmentSize {
+		errs = append(errs, fmt.Errorf("data_channel_fragment_size must be between 1 and %d", maxDataChannelFragmentSize))
+	}
+	if c.ResultSaveInterval == 0 {
+		errs = append(errs, errors.New("result_save_interval must be positive"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/transport"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/report"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// InterceptorSet names a group of interceptors that can be enabled per deployment
+type InterceptorSet string
+
+const (
+	// NACK responder for the point cloud track and NACK generator for incoming tracks
+	InterceptorNACK InterceptorSet = "nack"
+	// Transport-wide sequence numbers on outgoing packets and TWCC feedback for incoming tracks
+	InterceptorTWCC InterceptorSet = "twcc"
+	// Send side bandwidth estimation, requires twcc
+	InterceptorGCC InterceptorSet = "gcc"
+	// RTCP sender and receiver reports
+	InterceptorRTCPReports InterceptorSet = "rtcp_reports"
+	// Per stream RTP / RTCP statistics
+	InterceptorStats InterceptorSet = "stats"
+	// Answer NACKs on a separate RTX SSRC and payload type instead of the original stream, requires nack
+	InterceptorRTX InterceptorSet = "rtx"
+)
+
+// DefaultInterceptorSets are the sets that are enabled when the configuration does not list any
+var DefaultInterceptorSets = []InterceptorSet{InterceptorNACK, InterceptorTWCC, InterceptorGCC}
+
+var knownInterceptorSets = map[InterceptorSet]bool{
+	InterceptorNACK:        true,
+	InterceptorTWCC:        true,
+	InterceptorGCC:         true,
+	InterceptorRTCPReports: true,
+	InterceptorStats:       true,
+	InterceptorRTX:         true,
+}
+
+// InterceptorPipeline builds the media engine and interceptor registry of the WebRTC API of a
+// client. It is the only place where interceptors are configured, individual and shared encoding
+// both go through it.
+type InterceptorPipeline struct {
+	config PeerConnectionConfig
+	sets   map[InterceptorSet]bool
+
+	// Called with the bandwidth estimator of the connection when gcc is enabled
+	OnEstimator func(cc.BandwidthEstimator)
+	// Called with the statistics getter of the connection when stats is enabled
+	OnStats func(stats.Getter)
+	// Called with the retransmission responder of the connection when rtx is enabled
+	OnRTX func(*transport.RTXResponderInterceptor)
+}
+
+func NewInterceptorPipeline(config PeerConnectionConfig) *InterceptorPipeline {
+	p := &InterceptorPipeline{
+		config: config,
+		sets:   make(map[InterceptorSet]bool),
+	}
+	sets := config.Interceptors
+	if len(sets) == 0 {
+		sets = DefaultInterceptorSets
+	}
+	for _, set := range sets {
+		p.sets[set] = true
+	}
+	return p
+}
+
+// Enabled returns whether the interceptor set is part of the pipeline
+func (p *InterceptorPipeline) Enabled(set InterceptorSet) bool {
+	return p.sets[set]
+}
+
+// Build registers the codecs, feedback, header extensions and interceptors of all enabled sets
+func (p *InterceptorPipeline) Build() (*webrtc.MediaEngine, *interceptor.Registry, error) {
+	m, err := transport.NewMediaEngine()
+	if err != nil {
+		return nil, nil, err
+	}
+	i := &interceptor.Registry{}
+
+	if p.Enabled(InterceptorGCC) {
+		congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+			pacer := transport.NewPacer(p.config.InitialBitrate, p.config.PacingMultiplier, p.config.PacingBurst)
+			return gcc.NewSendSideBWE(gcc.SendSideBWEMinBitrate(p.config.MinBitrate), gcc.SendSideBWEInitialBitrate(p.config.InitialBitrate), gcc.SendSideBWEMaxBitrate(p.config.MaxBitrate), gcc.SendSideBWEPacer(pacer))
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		congestionController.OnNewPeerConnection(func(id string, estimator cc.BandwidthEstimator) {
+			if p.OnEstimator != nil {
+				p.OnEstimator(estimator)
+			}
+		})
+		i.Add(congestionController)
+	}
+
+	if p.Enabled(InterceptorNACK) {
+		m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+		m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+		// With rtx the retransmissions are answered by the RTX responder
+		if !p.Enabled(InterceptorRTX) {
+			responder, err := nack.NewResponderInterceptor()
+			if err != nil {
+				return nil, nil, err
+			}
+			i.Add(responder)
+		}
+		generator, err := nack.NewGeneratorInterceptor()
+		if err != nil {
+			return nil, nil, err
+		}
+		i.Add(generator)
+	}
+
+	if p.Enabled(InterceptorTWCC) {
+		m.RegisterFeedback(webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC}, webrtc.RTPCodecTypeVideo)
+		if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.TransportCCURI}, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, nil, err
+		}
+		headerExtension, err := twcc.NewHeaderExtensionInterceptor()
+		if err != nil {
+			return nil, nil, err
+		}
+		sender, err := twcc.NewSenderInterceptor(twcc.SendInterval(time.Duration(p.config.TWCCSendInterval)))
+		if err != nil {
+			return nil, nil, err
+		}
+		i.Add(headerExtension)
+		i.Add(sender)
+	}
+
+	if p.Enabled(InterceptorRTX) {
+		if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: transport.PointCloudRTXCodecCapability(),
+			PayloadType:        transport.PointCloudRTXPayloadType,
+		}, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, nil, err
+		}
+		// Added after the TWCC header extension interceptor and the bandwidth estimator so
+		// retransmissions get their own transport-wide sequence numbers and are paced, and before
+		// the reports and stats so they are not counted as media
+		responder, err := transport.NewRTXResponderInterceptor()
+		if err != nil {
+			return nil, nil, err
+		}
+		responder.OnNewPeerConnection(func(id string, rtx *transport.RTXResponderInterceptor) {
+			if p.OnRTX != nil {
+				p.OnRTX(rtx)
+			}
+		})
+		i.Add(responder)
+	}
+
+	if p.Enabled(InterceptorRTCPReports) {
+		receiver, err := report.NewReceiverInterceptor(report.ReceiverInterval(time.Duration(p.config.RTCPReportInterval)))
+		if err != nil {
+			return nil, nil, err
+		}
+		sender, err := report.NewSenderInterceptor(report.SenderInterval(time.Duration(p.config.RTCPReportInterval)))
+		if err != nil {
+			return nil, nil, err
+		}
+		i.Add(receiver)
+		i.Add(sender)
+	}
+
+	if p.Enabled(InterceptorStats) {
+		statsInterceptor, err := stats.NewInterceptor()
+		if err != nil {
+			return nil, nil, err
+		}
+		statsInterceptor.OnNewPeerConnection(func(id string, getter stats.Getter) {
+			if p.OnStats != nil {
+				p.OnStats(getter)
+			}
+		})
+		i.Add(statsInterceptor)
+	}
+
+	return m, i, nil
+}
+
+func validateInterceptorSets(sets []InterceptorSet) error {
+	enabled := make(map[InterceptorSet]bool)
+	for _, set := range sets {
+		if !knownInterceptorSets[set] {
+			return fmt.Errorf("unknown interceptor set %q", set)
+		}
+		enabled[set] = true
+	}
+	if enabled[InterceptorGCC] && !enabled[InterceptorTWCC] {
+		return fmt.Errorf("interceptor set %q requires %q", InterceptorGCC, InterceptorTWCC)
+	}
+	if enabled[InterceptorRTX] && !enabled[InterceptorNACK] {
+		return fmt.Errorf("interceptor set %q requires %q", InterceptorRTX, InterceptorNACK)
+	}
+	return nil
+}
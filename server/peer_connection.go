@@ -0,0 +1,793 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/layered"
+	"github.com/MatthiasDeFre/webrtc-pc-server/metrics"
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+	"github.com/MatthiasDeFre/webrtc-pc-server/signaling"
+	"github.com/MatthiasDeFre/webrtc-pc-server/transcoder"
+	"github.com/MatthiasDeFre/webrtc-pc-server/transport"
+	"github.com/gorilla/websocket"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+type SendMessageCallback func(signaling.WebsocketPacket)
+type WebsocketCallback func(signaling.WebsocketPacket, *PeerConnection)
+type OnDisconnectedCb func(uint64)
+type OnConnectedCb func(uint64)
+
+type PeerConnectionFrame struct {
+	ClientID   uint64
+	FrameNr    uint32
+	FrameLen   uint32
+	CurrentLen uint32
+	FrameData  []byte
+	// ID of the track the frame was received on, every layer stream completes its part of a
+	// frame independently so the base layer is not held back by the enhancement layers
+	TrackID string
+}
+
+func NewPeerConnectionFrame(clientID uint64, frameNr uint32, frameLen uint32) *PeerConnectionFrame {
+	return &PeerConnectionFrame{clientID, frameNr, frameLen, 0, make([]byte, frameLen), ""}
+}
+
+func (pf *PeerConnectionFrame) IsComplete() bool {
+	return pf.CurrentLen == pf.FrameLen
+}
+
+// TODO Frame queue per connection
+type PeerConnection struct {
+	config                  PeerConnectionConfig
+	websocketConnection     *websocket.Conn
+	wbMutex                 sync.Mutex
+	signalingCodec          signaling.SignalingCodec
+	signalingMux            sync.Mutex
+	signalingState          signaling.SignalingState
+	negotiationPending      bool
+	webrtcConnection        *webrtc.PeerConnection
+	clientID                uint64
+	candidatesMux           sync.Mutex
+	pendingCandidates       []webrtc.ICECandidateInit
+	pendingRemoteCandidates []webrtc.ICECandidateInit
+	hasRemoteDescription    bool
+	// Local candidates are buffered while an answer is being created, so they are not sent before it
+	holdCandidates bool
+	estimator      cc.BandwidthEstimator
+	statsGetter    stats.Getter
+	rtx            *transport.RTXResponderInterceptor
+	// Point cloud tracks of the rtp transport, one per layer stream, base layer first
+	streams     []*layerStream
+	frameWriter transport.FrameWriter
+	sendQueue   *sendQueue
+	transcoder  transcoder.Transcoder
+	isIndi      bool
+	ipFilter    func(net.IP) bool
+
+	completedFramesChannel *RingChannel
+	// Read by the server loops without stateMux, only set while holding it
+	isReady atomic.Bool
+
+	panZoomMux     sync.Mutex
+	currentPanZoom pointcloud.PanZoom
+	hasPanZoom     bool
+	poseTracker    *pointcloud.PoseTracker
+	// Layers this client was sent, only used by the goroutine that encodes its frames
+	layerSelection *layered.LayerSelection
+
+	frameResultWriter *metrics.FrameResultWriter
+	currentFrameNr    uint64
+
+	wsCb  WebsocketCallback
+	conCb OnConnectedCb
+	dscCb OnDisconnectedCb
+
+	// Closed on teardown, all goroutines owned by this client stop when it is
+	done      chan struct{}
+	closeOnce sync.Once
+	trackWg   sync.WaitGroup
+	stateMux  sync.Mutex
+	// Set by Close under stateMux, no track reader is added afterwards
+	closed          bool
+	hasConnected    bool
+	disconnectTimer *time.Timer
+}
+
+// NewPeerConnection creates a client, when transcoder is not nil the client uses individual
+// encoding and pulls its own frames from it instead of receiving the shared ones
+func NewPeerConnection(clientID uint64, websocketConnection *websocket.Conn, config PeerConnectionConfig, resultDirectory string, newCodec signaling.NewSignalingCodecFunc, wsCb WebsocketCallback, transcoder transcoder.Transcoder) (*PeerConnection, error) {
+	frameResultWriter, err := metrics.NewFrameResultWriter(filepath.Join(resultDirectory, strconv.Itoa(int(clientID))), config.ResultSaveInterval)
+	if err != nil {
+		return nil, err
+	}
+	pc := &PeerConnection{
+		websocketConnection:     websocketConnection,
+		config:                  config,
+		wbMutex:                 sync.Mutex{},
+		signalingCodec:          newCodec(clientID),
+		clientID:                clientID,
+		candidatesMux:           sync.Mutex{},
+		pendingCandidates:       make([]webrtc.ICECandidateInit, 0),
+		pendingRemoteCandidates: make([]webrtc.ICECandidateInit, 0),
+		completedFramesChannel:  NewRingChannel(config.ReceiveRingSize),
+		sendQueue:               newSendQueue(config.SendQueueSize),
+		poseTracker:             pointcloud.NewPoseTracker(config.PoseSmoothing),
+		layerSelection:          layered.NewLayerSelection(),
+		frameResultWriter:       frameResultWriter,
+		done:                    make(chan struct{}),
+		currentFrameNr:          0,
+		transcoder:              transcoder,
+		isIndi:                  transcoder != nil,
+	}
+	pc.wsCb = wsCb
+	return pc, nil
+}
+
+func (pc *PeerConnection) NewWebrtcAPI() (*webrtc.API, error) {
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetSCTPMaxReceiveBufferSize(pc.config.SCTPMaxReceiveBufferSize)
+	if pc.ipFilter != nil {
+		settingEngine.SetIPFilter(pc.ipFilter)
+	}
+
+	pipeline := NewInterceptorPipeline(pc.config)
+	pipeline.OnEstimator = pc.SetEstimator
+	pipeline.OnStats = pc.setStatsGetter
+	pipeline.OnRTX = func(rtx *transport.RTXResponderInterceptor) { pc.rtx = rtx }
+	m, i, err := pipeline.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine), webrtc.WithInterceptorRegistry(i), webrtc.WithMediaEngine(m)), nil
+}
+
+// Init creates the WebRTC connection, if sendOffer is false the server waits for
+// the client to either send an offer or a hello message
+func (pc *PeerConnection) Init(sendOffer bool) error {
+	api, err := pc.NewWebrtcAPI()
+	if err != nil {
+		return err
+	}
+	webrtcConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return err
+	}
+	pc.webrtcConnection = webrtcConnection
+	// ------------------ Callbacks ------------------
+	webrtcConnection.OnICECandidate(pc.OnIceCandidateCb)
+	webrtcConnection.OnConnectionStateChange(pc.OnConnectionStateChangeCb)
+	webrtcConnection.OnTrack(pc.OnTrackCb)
+	// -----------------------------------------------
+	if pc.config.Transport == TransportDataChannel {
+		err = pc.addDataChannel()
+	} else {
+		err = pc.addPointCloudTracks()
+	}
+	if err != nil {
+		return err
+	}
+
+	pc.signalingMux.Lock()
+	if sendOffer {
+		err = pc.sendOffer()
+	}
+	pc.signalingMux.Unlock()
+	if err != nil {
+		return err
+	}
+	// Only start handling messages once the WebRTC connection exists
+	pc.StartListeningWebsocket(pc.wsCb)
+	return nil
+}
+
+// layerStream is a point cloud track and the SSRCs it was negotiated with
+type layerStream struct {
+	track  *transport.TrackLocalCloudRTP
+	sender *webrtc.RTPSender
+	ssrc   webrtc.SSRC
+	// Zero when rtx is disabled
+	rtxSSRC webrtc.SSRC
+}
+
+// addPointCloudTracks adds the RTP tracks that carry the frames of the rtp transport. With a
+// single layer stream the track has ID video, otherwise the tracks are layer0, layer1, ... and
+// share the stream ID so the client can group them.
+func (pc *PeerConnection) addPointCloudTracks() error {
+	codecCap := transport.PointCloudCodecCapability()
+	codecCap.RTCPFeedback = nil
+	usedSSRCs := make(map[webrtc.SSRC]bool)
+	tracks := make([]*transport.TrackLocalCloudRTP, 0, pc.config.LayerStreams)
+	for i := 0; i < pc.config.LayerStreams; i++ {
+		id := "video"
+		if pc.config.LayerStreams > 1 {
+			id = fmt.Sprintf("layer%d", i)
+		}
+		videoTrack, err := transport.NewTrackLocalCloudRTP(codecCap, id, "pion")
+		if err != nil {
+			return err
+		}
+		videoTrack.SetFragmentSize(uint32(pc.config.FragmentSize))
+		videoTrack.SetPacketVersion(pc.config.FramePacketVersion)
+		if pc.rtx != nil {
+			// Leave room for the original sequence number of a retransmission
+			videoTrack.SetMTU(uint16(pc.config.MTU - transport.RTXOverhead))
+		} else {
+			videoTrack.SetMTU(uint16(pc.config.MTU))
+		}
+		videoTrack.SetFECRatios(pc.config.FECRatios)
+		// RTP Sender
+		rtpSender, err := pc.webrtcConnection.AddTrack(videoTrack)
+		if err != nil {
+			return err
+		}
+		stream := &layerStream{track: videoTrack, sender: rtpSender}
+		if encodings := rtpSender.GetParameters().Encodings; len(encodings) > 0 {
+			stream.ssrc = encodings[0].SSRC
+			usedSSRCs[stream.ssrc] = true
+		}
+		pc.streams = append(pc.streams, stream)
+		tracks = append(tracks, videoTrack)
+		go readRTCP(rtpSender)
+	}
+	if pc.rtx != nil {
+		// Announced in every local description, used once the remote negotiated RTX
+		for _, stream := range pc.streams {
+			for stream.rtxSSRC == 0 || usedSSRCs[stream.rtxSSRC] {
+				stream.rtxSSRC = webrtc.SSRC(rand.Uint32())
+			}
+			usedSSRCs[stream.rtxSSRC] = true
+		}
+	}
+	if len(tracks) == 1 {
+		pc.frameWriter = tracks[0]
+	} else {
+		pc.frameWriter = transport.NewLayeredFrameWriter(tracks)
+	}
+	return nil
+}
+
+// addDataChannel creates the data channel that carries the frames of the datachannel transport,
+// it is part of the offers of the server and opened as soon as SCTP is connected
+func (pc *PeerConnection) addDataChannel() error {
+	ordered := pc.config.DataChannelOrdered
+	init := &webrtc.DataChannelInit{Ordered: &ordered}
+	if pc.config.DataChannelMaxRetransmits >= 0 {
+		maxRetransmits := uint16(pc.config.DataChannelMaxRetransmits)
+		init.MaxRetransmits = &maxRetransmits
+	}
+	if pc.config.DataChannelMaxPacketLifeTime > 0 {
+		maxPacketLifeTime := uint16(time.Duration(pc.config.DataChannelMaxPacketLifeTime) / time.Millisecond)
+		init.MaxPacketLifeTime = &maxPacketLifeTime
+	}
+	channel, err := pc.webrtcConnection.CreateDataChannel(transport.DataChannelLabel, init)
+	if err != nil {
+		return err
+	}
+	pc.frameWriter = transport.NewDataChannelFrameWriter(channel, uint32(pc.config.DataChannelFragmentSize))
+	return nil
+}
+
+// readRTCP drains the RTCP packets of a sender so the interceptors can process them
+func readRTCP(rtpSender *webrtc.RTPSender) {
+	rtcpBuf := make([]byte, 1500)
+	for {
+		if _, _, err := rtpSender.Read(rtcpBuf); err != nil {
+			return
+		}
+	}
+}
+
+// SetRemoteDescription applies the remote description and flushes the candidates
+// that were buffered on both sides while it was missing
+func (pc *PeerConnection) SetRemoteDescription(desc webrtc.SessionDescription) error {
+	if err := pc.webrtcConnection.SetRemoteDescription(desc); err != nil {
+		return err
+	}
+	pc.candidatesMux.Lock()
+	defer pc.candidatesMux.Unlock()
+	pc.hasRemoteDescription = true
+	pc.flushLocalCandidates()
+	var candidateErr error
+	for _, c := range pc.pendingRemoteCandidates {
+		if err := pc.webrtcConnection.AddICECandidate(c); err != nil && candidateErr == nil {
+			candidateErr = err
+		}
+	}
+	pc.pendingRemoteCandidates = pc.pendingRemoteCandidates[:0]
+	return candidateErr
+}
+
+// AddICECandidate applies a remote candidate, or buffers it until the remote description is known
+func (pc *PeerConnection) AddICECandidate(candidate webrtc.ICECandidateInit) error {
+	pc.candidatesMux.Lock()
+	defer pc.candidatesMux.Unlock()
+	if !pc.hasRemoteDescription {
+		pc.pendingRemoteCandidates = append(pc.pendingRemoteCandidates, candidate)
+		return nil
+	}
+	return pc.webrtcConnection.AddICECandidate(candidate)
+}
+
+// holdLocalCandidates buffers local candidates until releaseLocalCandidates, even when the remote
+// description is set
+func (pc *PeerConnection) holdLocalCandidates() {
+	pc.candidatesMux.Lock()
+	defer pc.candidatesMux.Unlock()
+	pc.holdCandidates = true
+}
+
+// releaseLocalCandidates sends the buffered local candidates if the remote description is set
+func (pc *PeerConnection) releaseLocalCandidates() {
+	pc.candidatesMux.Lock()
+	defer pc.candidatesMux.Unlock()
+	pc.holdCandidates = false
+	pc.flushLocalCandidates()
+}
+
+// flushLocalCandidates must be called with candidatesMux held
+func (pc *PeerConnection) flushLocalCandidates() {
+	if !pc.hasRemoteDescription || pc.holdCandidates {
+		return
+	}
+	for _, c := range pc.pendingCandidates {
+		pc.sendCandidate(c)
+	}
+	pc.pendingCandidates = pc.pendingCandidates[:0]
+}
+
+func (pc *PeerConnection) sendCandidate(candidate webrtc.ICECandidateInit) {
+	if payload, ok := pc.signalingCodec.EncodeCandidate(candidate); ok {
+		pc.SendWebsocketMessage(signaling.WebsocketPacket{ClientID: pc.clientID, MessageType: signaling.MessageTypeCandidate, Message: payload})
+	}
+}
+
+func (pc *PeerConnection) SetEstimator(estimator cc.BandwidthEstimator) {
+	pc.estimator = estimator
+}
+
+// RTXStats returns the retransmission counters, zero when the rtx interceptor set is disabled
+func (pc *PeerConnection) RTXStats() transport.RTXStats {
+	if pc.rtx == nil {
+		return transport.RTXStats{}
+	}
+	return pc.rtx.Stats()
+}
+
+func (pc *PeerConnection) setStatsGetter(getter stats.Getter) {
+	pc.statsGetter = getter
+}
+
+// OutboundStats returns the statistics of the point cloud track of a layer stream, nil when the
+// stats interceptor set is disabled, the stream does not exist or nothing has been sent yet
+func (pc *PeerConnection) OutboundStats(layerStream int) *stats.Stats {
+	if pc.statsGetter == nil || layerStream < 0 || layerStream >= len(pc.streams) || pc.streams[layerStream].ssrc == 0 {
+		return nil
+	}
+	return pc.statsGetter.Get(uint32(pc.streams[layerStream].ssrc))
+}
+func (pc *PeerConnection) StartListeningWebsocket(wsCb WebsocketCallback) {
+	go func() {
+		// A bug triggered by one client must not take down the other clients
+		defer func() {
+			if r := recover(); r != nil {
+				pc.Close(fmt.Errorf("panic in signaling handler: %v", r))
+			}
+		}()
+		for {
+			_, message, err := pc.websocketConnection.ReadMessage()
+			if err != nil {
+				pc.Close(fmt.Errorf("websocket read: %w", err))
+				return
+			}
+			wsPacket, err := pc.signalingCodec.Decode(message)
+			if err != nil {
+				pc.SendError(err)
+				continue
+			}
+			wsPacket.ClientID = pc.clientID
+			// TODO Potential clash => adding new client => currently reading from it
+			// Complete peer connection initilisation
+			wsCb(wsPacket, pc)
+		}
+	}()
+}
+
+// SendWebsocketMessage writes a message to the client, a failed write tears the connection down
+func (pc *PeerConnection) SendWebsocketMessage(wsPacket signaling.WebsocketPacket) error {
+	pc.wbMutex.Lock()
+	defer pc.wbMutex.Unlock()
+	s, err := pc.signalingCodec.Encode(wsPacket)
+	if err != nil {
+		logClient(pc.clientID, "encode_failed", err)
+		return err
+	}
+	if err = pc.websocketConnection.WriteMessage(websocket.TextMessage, s); err != nil {
+		// Close needs the websocket lock, run it once this write has returned
+		go pc.Close(fmt.Errorf("websocket write: %w", err))
+		return err
+	}
+	return nil
+}
+
+// SendError replies to the client with the reason its last message was rejected
+func (pc *PeerConnection) SendError(err error) {
+	logClient(pc.clientID, "rejected_message", err)
+	pc.SendWebsocketMessage(signaling.WebsocketPacket{ClientID: pc.clientID, MessageType: signaling.MessageTypeError, Message: err.Error()})
+}
+
+// Close is the single teardown path of a client, triggered by a websocket close, an ICE
+// disconnect timeout / failure or a bye message. It stops the goroutines of this client,
+// closes the WebRTC connection and websocket, flushes the result files and lets the
+// disconnected callback remove the client from the server. Safe to call multiple times.
+func (pc *PeerConnection) Close(reason error) {
+	pc.closeOnce.Do(func() {
+		logClient(pc.clientID, "teardown", reason)
+		close(pc.done)
+		pc.sendQueue.Close()
+		pc.CloseSignaling()
+		pc.stateMux.Lock()
+		pc.closed = true
+		pc.isReady.Store(false)
+		if pc.disconnectTimer != nil {
+			pc.disconnectTimer.Stop()
+		}
+		pc.stateMux.Unlock()
+		if pc.webrtcConnection != nil {
+			if err := pc.webrtcConnection.Close(); err != nil {
+				logClient(pc.clientID, "webrtc_close_failed", err)
+			}
+		}
+		pc.websocketConnection.Close()
+		// Track readers stop once the WebRTC connection is closed
+		pc.trackWg.Wait()
+		pc.completedFramesChannel.Close()
+		if err := pc.frameResultWriter.Close(); err != nil {
+			logClient(pc.clientID, "result_writer_close_failed", err)
+		}
+		if pc.dscCb != nil {
+			pc.dscCb(pc.clientID)
+		}
+	})
+}
+
+// Goodbye tells the client the server is leaving before tearing it down
+func (pc *PeerConnection) Goodbye(reason error) {
+	pc.SendWebsocketMessage(signaling.WebsocketPacket{ClientID: pc.clientID, MessageType: signaling.MessageTypeBye, Message: reason.Error()})
+	pc.Close(reason)
+}
+
+// Done is closed once the client has been torn down
+func (pc *PeerConnection) Done() <-chan struct{} {
+	return pc.done
+}
+
+// logClient writes a structured log line for a single client
+func logClient(clientID uint64, event string, reason error) {
+	log.Printf("client=%d event=%s reason=%q", clientID, event, fmt.Sprint(reason))
+}
+
+// SetIPFilter restricts the interfaces used for ICE, has to be set before Init
+func (pc *PeerConnection) SetIPFilter(filter func(net.IP) bool) {
+	pc.ipFilter = filter
+}
+
+func (pc *PeerConnection) SetOnConnectedCb(cb OnConnectedCb) {
+	pc.conCb = cb
+}
+func (pc *PeerConnection) SetOnDisconnectedCb(cb OnDisconnectedCb) {
+	pc.dscCb = cb
+}
+
+// TODO Pass global wsHandler?
+// OnIceCandidateCb trickles local candidates to the client, a nil candidate means gathering is complete
+func (pc *PeerConnection) OnIceCandidateCb(c *webrtc.ICECandidate) {
+	candidate := webrtc.ICECandidateInit{}
+	if c != nil {
+		candidate = c.ToJSON()
+	}
+	pc.candidatesMux.Lock()
+	defer pc.candidatesMux.Unlock()
+	if !pc.hasRemoteDescription || pc.holdCandidates {
+		pc.pendingCandidates = append(pc.pendingCandidates, candidate)
+	} else {
+		pc.sendCandidate(candidate)
+	}
+}
+
+// TODO Change implentation => add connection to completed clients
+func (pc *PeerConnection) OnConnectionStateChangeCb(s webrtc.PeerConnectionState) {
+	logClient(pc.clientID, "connection_state", errors.New(s.String()))
+	if s == webrtc.PeerConnectionStateFailed {
+		go pc.Close(errors.New("peer connection failed"))
+	} else if s == webrtc.PeerConnectionStateDisconnected {
+		// Give ICE the chance to recover before tearing the client down
+		pc.stateMux.Lock()
+		if pc.disconnectTimer == nil {
+			timeout := time.Duration(pc.config.DisconnectTimeout)
+			pc.disconnectTimer = time.AfterFunc(timeout, func() {
+				pc.Close(fmt.Errorf("ice disconnected for more than %s", timeout))
+			})
+		}
+		pc.stateMux.Unlock()
+	} else if s == webrtc.PeerConnectionStateConnected {
+		pc.stateMux.Lock()
+		if pc.disconnectTimer != nil {
+			pc.disconnectTimer.Stop()
+			pc.disconnectTimer = nil
+		}
+		// A connection that completes during teardown is not used anymore
+		if !pc.closed {
+			pc.isReady.Store(true)
+		}
+		// Reconnecting after a disconnect does not start a new session
+		wasConnected := pc.hasConnected
+		pc.hasConnected = true
+		pc.stateMux.Unlock()
+		if wasConnected {
+			return
+		}
+		if pc.conCb != nil {
+			pc.conCb(pc.clientID)
+		}
+		go pc.sendLoop()
+		if pc.isIndi {
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						pc.Close(fmt.Errorf("panic in frame sender: %v", r))
+					}
+				}()
+				for {
+					frameNr, frame, captureTime := pc.transcoder.NextFrame()
+					select {
+					case <-pc.done:
+						return
+					default:
+					}
+					pc.QueueFrame(pc.transcoder.EncodeFrame(frame, frameNr, captureTime, pc.EncodingBitrate(), pc.Viewport(), pc.layerSelection))
+				}
+			}()
+		}
+
+	} else if s == webrtc.PeerConnectionStateClosed {
+		go pc.Close(errors.New("peer connection closed"))
+	}
+}
+
+// Parameter => connection ID
+func (pc *PeerConnection) OnTrackCb(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	// Close waits for the track readers, so none may start once it does
+	pc.stateMux.Lock()
+	if pc.closed {
+		pc.stateMux.Unlock()
+		return
+	}
+	pc.trackWg.Add(1)
+	pc.stateMux.Unlock()
+	defer pc.trackWg.Done()
+
+	logClient(pc.clientID, "track_started", fmt.Errorf("track %s, %s, payload type %d", track.ID(), track.Codec().MimeType, track.PayloadType()))
+
+	buf := make([]byte, 1500)
+	rtpPacket := &rtp.Packet{}
+	fecDecoder := transport.NewFECDecoder()
+	layer := layerTrackIndex(track.ID())
+	reassembler := transport.NewReassembler(time.Duration(pc.config.ReassemblyDeadline), pc.config.MaxFrameSize, pc.config.ReassemblyBufferSize)
+
+	for {
+		n, _, readErr := track.Read(buf)
+		if readErr != nil {
+			logClient(pc.clientID, "track_closed", readErr)
+			return
+		}
+		if err := rtpPacket.Unmarshal(buf[:n]); err != nil {
+			logClient(pc.clientID, "invalid_rtp_packet", err)
+			continue
+		}
+		var fragments []*transport.FramePacket
+		if transport.IsFECPacket(rtpPacket.Payload) {
+			parity, err := transport.ParseFECPacket(rtpPacket.Payload)
+			if err != nil {
+				logClient(pc.clientID, "invalid_fec_packet", err)
+				continue
+			}
+			fragments = fecDecoder.AddParity(parity)
+		} else {
+			// Both the compact and the legacy fragment format are accepted
+			p, _, err := transport.ParseFramePacket(rtpPacket.Payload)
+			if err != nil {
+				logClient(pc.clientID, "invalid_frame_packet", err)
+				continue
+			}
+			fragments = append([]*transport.FramePacket{p}, fecDecoder.AddFragment(p)...)
+		}
+		now := time.Now()
+		for _, stats := range reassembler.Expire(now) {
+			logClient(pc.clientID, "frame_dropped", fmt.Errorf("frame %d: %d of %d bytes in %d ranges missing after %s", stats.FrameNr, stats.FrameLen-stats.ReceivedBytes, stats.FrameLen, len(stats.Missing), stats.Latency()))
+			pc.saveReceivedFrame(layer, stats)
+			fecDecoder.Forget(stats.FrameNr)
+		}
+		for _, p := range fragments {
+			reassembled, err := reassembler.Add(p, now)
+			if err != nil {
+				logClient(pc.clientID, "invalid_frame_packet", err)
+				continue
+			}
+			if reassembled == nil {
+				continue
+			}
+			pc.saveReceivedFrame(layer, reassembled.Stats)
+			fecDecoder.Forget(reassembled.FrameNr)
+			frame := &PeerConnectionFrame{pc.clientID, reassembled.FrameNr, reassembled.FrameLen, reassembled.FrameLen, reassembled.Data, track.ID()}
+			// Will drop oldest frame if capacity is full
+			select {
+			case pc.completedFramesChannel.In() <- frame:
+			case <-pc.done:
+				return
+			}
+		}
+	}
+
+}
+
+// layerTrackIndex returns i for the track IDs layer<i> of clients that send every layer on its own
+// track, 0 for other tracks
+func layerTrackIndex(id string) uint32 {
+	if !strings.HasPrefix(id, "layer") {
+		return 0
+	}
+	index, err := strconv.ParseUint(strings.TrimPrefix(id, "layer"), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(index)
+}
+
+// saveReceivedFrame writes the reception statistics of a completed or dropped frame of a layer track
+func (pc *PeerConnection) saveReceivedFrame(layer uint32, stats transport.FrameStats) {
+	pc.frameResultWriter.CreateRecord(stats.FrameNr, layer, stats.FirstPacket.UnixNano()/int64(time.Millisecond), false)
+	pc.frameResultWriter.SetSizeInBytes(stats.FrameNr, layer, stats.FrameLen, false)
+	pc.frameResultWriter.SetReception(stats.FrameNr, layer, stats.ReceivedBytes, stats.Duplicates, stats.Complete)
+	pc.frameResultWriter.SetProcessingCompleteTimestamp(stats.FrameNr, layer, stats.Done.UnixNano()/int64(time.Millisecond), false)
+	pc.frameResultWriter.SaveRecord(stats.FrameNr, layer, false)
+}
+
+// EncodingBitrate is the part of the estimated bitrate that is left for frame data
+// once the FEC parity packets are accounted for
+func (pc *PeerConnection) EncodingBitrate() uint32 {
+	return uint32(float64(pc.GetBitrate()) / (1 + transport.FECOverhead(pc.config.FECRatios)))
+}
+
+// GetBitrate returns the estimated bitrate, or the configured initial bitrate when gcc is disabled
+func (pc *PeerConnection) GetBitrate() uint32 {
+	if pc.estimator == nil {
+		return uint32(pc.config.InitialBitrate)
+	}
+	return uint32(pc.estimator.GetTargetBitrate())
+}
+
+func (pc *PeerConnection) GetFrameCounter() uint32 {
+	return uint32(pc.currentFrameNr)
+}
+
+func (pc *PeerConnection) GetPanZoom() pointcloud.PanZoom {
+	pc.panZoomMux.Lock()
+	defer pc.panZoomMux.Unlock()
+	return pc.currentPanZoom
+}
+
+func (pc *PeerConnection) GetRemoteDescription() *webrtc.SessionDescription {
+	return pc.webrtcConnection.RemoteDescription()
+}
+
+func (pc *PeerConnection) SetPanZoom(pz pointcloud.PanZoom) {
+	pc.panZoomMux.Lock()
+	defer pc.panZoomMux.Unlock()
+	pc.currentPanZoom = pz
+	pc.hasPanZoom = true
+	pc.poseTracker.Update(pz, time.Now())
+}
+
+// Viewport returns the smoothed pose of the viewer extrapolated to the time a frame that is
+// encoded now is displayed, nil when the client did not report a pose yet
+func (pc *PeerConnection) Viewport() *pointcloud.Viewport {
+	pc.panZoomMux.Lock()
+	defer pc.panZoomMux.Unlock()
+	if !pc.hasPanZoom {
+		return nil
+	}
+	return &pointcloud.Viewport{
+		Pose:          pc.poseTracker.Predict(time.Now().Add(time.Duration(pc.config.PosePrediction))),
+		HorizontalFOV: pc.config.HorizontalFOV,
+		VerticalFOV:   pc.config.VerticalFOV,
+	}
+}
+
+// QueueFrame adds an encoded frame to the send queue of the client without blocking, it has to be
+// sent within the send deadline of its capture time
+func (pc *PeerConnection) QueueFrame(frame *pointcloud.Frame) {
+	if frame == nil {
+		return
+	}
+	captureTime := frame.CaptureTime
+	if captureTime.IsZero() {
+		captureTime = time.Now()
+	}
+	pc.sendQueue.Push(frame, captureTime.Add(time.Duration(pc.config.SendDeadline)))
+}
+
+// SendQueueStats returns the number of frames that were dropped or reduced to their base layer
+func (pc *PeerConnection) SendQueueStats() SendQueueStats {
+	return pc.sendQueue.Stats()
+}
+
+// sendLoop sends the queued frames until the client is torn down. A frame that cannot be sent
+// before its deadline at the current rate is reduced to its base layer.
+func (pc *PeerConnection) sendLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			pc.Close(fmt.Errorf("panic in frame sender: %v", r))
+		}
+	}()
+	for {
+		frame, deadline, ok := pc.sendQueue.Pop()
+		if !ok {
+			return
+		}
+		if remaining := time.Until(deadline); pc.sendDuration(len(frame.Data)) > remaining {
+			if base := layered.BaseLayerFrame(frame); base != nil {
+				frame = base
+				pc.sendQueue.CountBaseLayerOnly()
+			}
+		}
+		pc.SendFrame(frame)
+	}
+}
+
+// sendDuration estimates the time it takes to send size bytes at the pacing rate, or at the
+// estimated bitrate when pacing is disabled
+func (pc *PeerConnection) sendDuration(size int) time.Duration {
+	rate := float64(pc.GetBitrate())
+	if pc.config.PacingMultiplier > 0 {
+		rate *= pc.config.PacingMultiplier
+	}
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(size) * 8 / rate * float64(time.Second))
+}
+
+func (pc *PeerConnection) SendFrame(frame *pointcloud.Frame) {
+	if frame != nil {
+		pc.frameResultWriter.CreateRecord(uint32(frame.FrameNr), 0, time.Now().UnixNano()/int64(time.Millisecond), true)
+		pc.frameResultWriter.SetEstimatedBitrate(uint32(frame.FrameNr), pc.GetBitrate())
+		pc.frameResultWriter.SetSizeInBytes(uint32(frame.FrameNr), 0, frame.FrameLen, true)
+		rtxStats := pc.RTXStats()
+		pc.frameResultWriter.SetRetransmissions(uint32(frame.FrameNr), rtxStats.Packets, rtxStats.Bytes)
+		queueStats := pc.SendQueueStats()
+		pc.frameResultWriter.SetDroppedFrames(uint32(frame.FrameNr), queueStats.Late, queueStats.Superseded, queueStats.BaseLayerOnly)
+
+		if err := pc.frameWriter.WriteFrame(frame); err != nil {
+			logClient(pc.clientID, "write_frame_failed", err)
+		}
+
+		pc.frameResultWriter.SetProcessingCompleteTimestamp(uint32(frame.FrameNr), 0, time.Now().UnixNano()/int64(time.Millisecond), true)
+		pc.frameResultWriter.SaveRecord(uint32(frame.FrameNr), 0, true)
+	}
+	//pc.currentFrameNr++
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+// SendQueueStats counts the frames that were not sent as they were encoded
+type SendQueueStats struct {
+	// Frames that were dropped because their deadline had passed
+	Late uint64
+	// Frames that were dropped because the queue was full and a newer frame was added
+	Superseded uint64
+	// Frames of which only the base layer was sent because the full frame would miss its deadline
+	BaseLayerOnly uint64
+}
+
+type queuedFrame struct {
+	frame    *pointcloud.Frame
+	deadline time.Time
+}
+
+// sendQueue holds the encoded frames of a client until its sender is ready for them. Adding a
+// frame never blocks, when the queue is full the oldest frame is dropped.
+type sendQueue struct {
+	mux    sync.Mutex
+	cond   *sync.Cond
+	frames []queuedFrame
+	size   int
+	closed bool
+	stats  SendQueueStats
+}
+
+func newSendQueue(size int) *sendQueue {
+	q := &sendQueue{size: size}
+	q.cond = sync.NewCond(&q.mux)
+	return q
+}
+
+// Push adds a frame that has to be sent before deadline
+func (q *sendQueue) Push(frame *pointcloud.Frame, deadline time.Time) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.closed {
+		return
+	}
+	if len(q.frames) >= q.size {
+		q.frames = q.frames[1:]
+		q.stats.Superseded++
+	}
+	q.frames = append(q.frames, queuedFrame{frame, deadline})
+	q.cond.Signal()
+}
+
+// Pop blocks until a frame is available, frames whose deadline has passed are dropped.
+// It returns false once the queue is closed.
+func (q *sendQueue) Pop() (*pointcloud.Frame, time.Time, bool) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	for {
+		for len(q.frames) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			return nil, time.Time{}, false
+		}
+		next := q.frames[0]
+		q.frames = q.frames[1:]
+		if time.Now().After(next.deadline) {
+			q.stats.Late++
+			continue
+		}
+		return next.frame, next.deadline, true
+	}
+}
+
+// CountBaseLayerOnly counts a frame of which only the base layer was sent
+func (q *sendQueue) CountBaseLayerOnly() {
+	q.mux.Lock()
+	q.stats.BaseLayerOnly++
+	q.mux.Unlock()
+}
+
+func (q *sendQueue) Stats() SendQueueStats {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.stats
+}
+
+// Close wakes up the sender and drops the queued frames
+func (q *sendQueue) Close() {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.closed = true
+	q.frames = nil
+	q.cond.Broadcast()
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+func TestSendQueueDropOldest(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		pushed uint32
+		// Frame numbers that are popped, in order
+		want           []uint32
+		wantSuperseded uint64
+	}{
+		{"below size", 3, 2, []uint32{1, 2}, 0},
+		{"at size", 2, 2, []uint32{1, 2}, 0},
+		{"one over", 2, 3, []uint32{2, 3}, 1},
+		{"size one", 1, 4, []uint32{4}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSendQueue(tt.size)
+			deadline := time.Now().Add(time.Hour)
+			for nr := uint32(1); nr <= tt.pushed; nr++ {
+				q.Push(&pointcloud.Frame{FrameNr: nr}, deadline)
+			}
+			var got []uint32
+			for range tt.want {
+				frame, _, ok := q.Pop()
+				if !ok {
+					t.Fatal("queue closed")
+				}
+				got = append(got, frame.FrameNr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("popped %v, want %v", got, tt.want)
+			}
+			if stats := q.Stats(); stats != (SendQueueStats{Superseded: tt.wantSuperseded}) {
+				t.Errorf("stats = %+v, want %d superseded", stats, tt.wantSuperseded)
+			}
+		})
+	}
+}
+
+func TestSendQueueDeadline(t *testing.T) {
+	q := newSendQueue(3)
+	now := time.Now()
+	q.Push(&pointcloud.Frame{FrameNr: 1}, now.Add(-time.Second))
+	q.Push(&pointcloud.Frame{FrameNr: 2}, now.Add(-time.Millisecond))
+	q.Push(&pointcloud.Frame{FrameNr: 3}, now.Add(time.Hour))
+
+	frame, deadline, ok := q.Pop()
+	if !ok || frame.FrameNr != 3 || !deadline.Equal(now.Add(time.Hour)) {
+		t.Fatalf("popped %+v %v %t, want frame 3", frame, deadline, ok)
+	}
+	if stats := q.Stats(); stats.Late != 2 || stats.Superseded != 0 {
+		t.Errorf("stats = %+v, want 2 late", stats)
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	q := newSendQueue(2)
+	popped := make(chan bool)
+	go func() {
+		_, _, ok := q.Pop()
+		popped <- ok
+	}()
+	select {
+	case <-popped:
+		t.Fatal("Pop returned from an empty queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.Close()
+	select {
+	case ok := <-popped:
+		if ok {
+			t.Error("Pop returned a frame after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock Pop")
+	}
+
+	// Frames pushed after Close are dropped
+	q.Push(&pointcloud.Frame{FrameNr: 1}, time.Now().Add(time.Hour))
+	if _, _, ok := q.Pop(); ok {
+		t.Error("Pop returned a frame pushed after Close")
+	}
+}
+
+func TestSendLoopStopsOnClose(t *testing.T) {
+	pc := &PeerConnection{sendQueue: newSendQueue(2)}
+	done := make(chan struct{})
+	go func() {
+		pc.sendLoop()
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	pc.sendQueue.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sendLoop is still waiting after Close")
+	}
+}
@@ -0,0 +1,347 @@
+// Package server ties signaling, transcoding and transport together into a
+// point cloud streaming server.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/proxy"
+	"github.com/MatthiasDeFre/webrtc-pc-server/signaling"
+	"github.com/MatthiasDeFre/webrtc-pc-server/transcoder"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// Server streams point clouds to every client that connects to its signaling server.
+// Several servers can run in the same process as long as their addresses and result
+// directories differ.
+type Server struct {
+	config            ServerConfig
+	proxyConn         *proxy.ProxyConnection
+	transcoder        transcoder.Transcoder
+	newSignalingCodec signaling.NewSignalingCodecFunc
+
+	pcMapMutex      sync.Mutex
+	peerConnections map[uint64]*PeerConnection
+	clientCounter   uint64
+
+	wsServer     *signaling.WebsocketHandler
+	done         chan struct{}
+	shutdownOnce sync.Once
+	// Frees the result directory for other servers, set once started
+	releaseResultDirectory func()
+}
+
+var (
+	resultDirectoriesMux sync.Mutex
+	// Result directories of the started servers of this process
+	resultDirectories = make(map[string]bool)
+)
+
+// claimResultDirectory reserves the result directory for a server and creates it, two servers
+// writing to the same directory would overwrite each other's result files
+func claimResultDirectory(dir string) (func(), error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	resultDirectoriesMux.Lock()
+	defer resultDirectoriesMux.Unlock()
+	if resultDirectories[abs] {
+		return nil, fmt.Errorf("result_directory %s is used by another server", dir)
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, err
+	}
+	resultDirectories[abs] = true
+	return func() {
+		resultDirectoriesMux.Lock()
+		delete(resultDirectories, abs)
+		resultDirectoriesMux.Unlock()
+	}, nil
+}
+
+type ServerOption func(*Server)
+
+// WithTranscoder replaces the transcoder that would be created from the configuration
+func WithTranscoder(transcoder transcoder.Transcoder) ServerOption {
+	return func(s *Server) {
+		s.transcoder = transcoder
+	}
+}
+
+// WithProxyConnection uses an already set up proxy connection instead of creating one on Start
+func WithProxyConnection(proxyConn *proxy.ProxyConnection) ServerOption {
+	return func(s *Server) {
+		s.proxyConn = proxyConn
+	}
+}
+
+// WithSignalingCodec replaces the signaling format that would be selected from the configuration
+func WithSignalingCodec(newCodec signaling.NewSignalingCodecFunc) ServerOption {
+	return func(s *Server) {
+		s.newSignalingCodec = newCodec
+	}
+}
+
+// NewServer creates a server, the configuration is validated when the server is started
+func NewServer(config ServerConfig, options ...ServerOption) *Server {
+	s := &Server{
+		config:          config,
+		peerConnections: make(map[uint64]*PeerConnection),
+		done:            make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.newSignalingCodec == nil {
+		s.newSignalingCodec = signaling.NewJSONSignalingCodec
+		if config.LegacySignaling {
+			s.newSignalingCodec = signaling.NewLegacySignalingCodec
+		}
+	}
+	return s
+}
+
+// Start connects to the proxy (if used), starts the signaling server and the frame loop.
+// When using the proxy this blocks until the capture application has connected.
+func (s *Server) Start() (err error) {
+	if err := s.config.Validate(); err != nil {
+		return err
+	}
+	release, err := claimResultDirectory(s.config.ResultDirectory)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			release()
+		} else {
+			s.releaseResultDirectory = release
+		}
+	}()
+	if s.config.UseProxy && s.proxyConn == nil {
+		s.proxyConn = proxy.NewProxyConnection(s.config.IsIndi, s.readyBitrates)
+		if err := s.proxyConn.SetupConnection(s.config.ProxyCaptureAddr, s.config.ProxyServerAddr); err != nil {
+			return err
+		}
+	}
+	if s.transcoder == nil {
+		if s.config.UseProxy {
+			if !s.config.IsIndi {
+				s.transcoder = transcoder.NewTranscoderRemote(s.proxyConn, s.config.PeerConnection.EncoderFrameRate, s.config.LayerPolicy)
+			}
+		} else {
+			s.transcoder = transcoder.NewTranscoderFile(s.config.ContentDirectory, s.config.ContentFrameRate, s.config.LayerPolicy)
+		}
+	}
+	wsServer, err := signaling.NewWSServer(s.config.SignalingAddr, s.onNewUser)
+	if err != nil {
+		return err
+	}
+	s.wsServer = wsServer
+	if !s.config.IsIndi {
+		go s.broadcastFrames()
+	}
+	return nil
+}
+
+// Shutdown stops accepting new clients, says goodbye to every connected client, closes
+// their connections (flushing their result files) and tells the capture proxy the server
+// is leaving. It returns ctx.Err() if the deadline of ctx passes before everything is closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() {
+		close(s.done)
+		if s.releaseResultDirectory != nil {
+			defer s.releaseResultDirectory()
+		}
+		if s.wsServer != nil {
+			err = s.wsServer.Shutdown(ctx)
+		}
+		drained := make(chan struct{})
+		var drainErr error
+		go func() {
+			defer close(drained)
+			var wg sync.WaitGroup
+			for _, pc := range s.PeerConnections() {
+				wg.Add(1)
+				go func(pc *PeerConnection) {
+					defer wg.Done()
+					pc.Goodbye(errors.New("server shutdown"))
+				}(pc)
+			}
+			wg.Wait()
+			if s.proxyConn != nil {
+				drainErr = s.proxyConn.Close()
+			}
+		}()
+		select {
+		case <-drained:
+			if err == nil {
+				err = drainErr
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+	return err
+}
+
+// PeerConnections returns a snapshot of the connected clients
+func (s *Server) PeerConnections() []*PeerConnection {
+	s.pcMapMutex.Lock()
+	defer s.pcMapMutex.Unlock()
+	pcs := make([]*PeerConnection, 0, len(s.peerConnections))
+	for _, pc := range s.peerConnections {
+		pcs = append(pcs, pc)
+	}
+	return pcs
+}
+
+// broadcastFrames encodes every frame of the shared transcoder for each ready client
+func (s *Server) broadcastFrames() {
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		frameNr, frame, captureTime := s.transcoder.NextFrame()
+		if frame == nil {
+			continue
+		}
+		s.pcMapMutex.Lock()
+		for _, pc := range s.peerConnections {
+			// Get frame from proxy = channel (maybe ring channel)
+			if pc.isReady.Load() {
+				pc.QueueFrame(s.transcoder.EncodeFrame(frame, frameNr, captureTime, pc.EncodingBitrate(), pc.Viewport(), pc.layerSelection))
+			}
+		}
+		s.pcMapMutex.Unlock()
+	}
+}
+
+// readyBitrates returns the estimated bitrate of every connected client, used by the proxy
+func (s *Server) readyBitrates() map[uint32]uint32 {
+	s.pcMapMutex.Lock()
+	defer s.pcMapMutex.Unlock()
+	bitrates := make(map[uint32]uint32)
+	for clientID, pc := range s.peerConnections {
+		if pc.isReady.Load() {
+			bitrates[uint32(clientID)] = pc.GetBitrate()
+		}
+	}
+	return bitrates
+}
+
+func (s *Server) virtualWallFilter(addr net.IP) bool {
+	return addr.String() == s.config.VirtualWallIP
+}
+
+func (s *Server) onNewUser(wsConn *websocket.Conn) {
+	s.pcMapMutex.Lock()
+	defer s.pcMapMutex.Unlock()
+	clientID := s.clientCounter
+	if s.config.MaxClients > 0 && len(s.peerConnections) >= s.config.MaxClients {
+		logClient(clientID, "rejected", fmt.Errorf("maximum of %d clients reached", s.config.MaxClients))
+		wsConn.Close()
+		return
+	}
+	logClient(clientID, "connected", fmt.Errorf("websocket from %s", wsConn.RemoteAddr()))
+	s.clientCounter++
+	var indiTranscoder transcoder.Transcoder
+	if s.config.IsIndi {
+		indiTranscoder = transcoder.NewTranscoderRemoteIndi(s.proxyConn, uint32(clientID))
+	}
+	pc, err := NewPeerConnection(clientID, wsConn, s.config.PeerConnection, s.config.ResultDirectory, s.newSignalingCodec, wsHandlerMessageCbFunc, indiTranscoder)
+	if err != nil {
+		logClient(clientID, "init_failed", err)
+		wsConn.Close()
+		return
+	}
+	if s.config.VirtualWallIP != "" {
+		pc.SetIPFilter(s.virtualWallFilter)
+	}
+	pc.SetOnConnectedCb(s.onPeerConnected)
+	pc.SetOnDisconnectedCb(s.onPeerDisconnected)
+	if err := pc.Init(!s.config.WaitForClientOffer); err != nil {
+		logClient(clientID, "init_failed", err)
+		// Not yet in the map, clean up without going through the disconnected callback
+		pc.SetOnDisconnectedCb(nil)
+		pc.Close(err)
+		return
+	}
+	s.peerConnections[clientID] = pc
+}
+
+func (s *Server) onPeerConnected(clientID uint64) {
+	if s.proxyConn != nil {
+		s.proxyConn.OnNewClientConnected(uint32(clientID))
+	}
+}
+
+func (s *Server) onPeerDisconnected(clientID uint64) {
+	s.pcMapMutex.Lock()
+	defer s.pcMapMutex.Unlock()
+	delete(s.peerConnections, clientID)
+	if s.proxyConn != nil {
+		s.proxyConn.OnNewClientDisconnected(uint32(clientID))
+	}
+}
+
+func wsHandlerMessageCbFunc(wsPacket signaling.WebsocketPacket, pc *PeerConnection) {
+	switch wsPacket.MessageType {
+	case signaling.MessageTypeHello:
+		if err := pc.HandleHello(); err != nil {
+			pc.SendError(err)
+		}
+	case signaling.MessageTypeOffer:
+		offer := webrtc.SessionDescription{}
+		if err := json.Unmarshal([]byte(wsPacket.Message), &offer); err != nil {
+			pc.SendError(fmt.Errorf("%w: %v", signaling.ErrMalformedMessage, err))
+			return
+		}
+		if err := pc.HandleOffer(offer); err != nil {
+			pc.SendError(err)
+		}
+	case signaling.MessageTypeAnswer:
+		answer := webrtc.SessionDescription{}
+		if err := json.Unmarshal([]byte(wsPacket.Message), &answer); err != nil {
+			pc.SendError(fmt.Errorf("%w: %v", signaling.ErrMalformedMessage, err))
+			return
+		}
+		if err := pc.HandleAnswer(answer); err != nil {
+			pc.SendError(err)
+		}
+	case signaling.MessageTypeCandidate:
+		candidate, err := pc.signalingCodec.DecodeCandidate(wsPacket.Message)
+		if err != nil {
+			pc.SendError(err)
+			return
+		}
+		if candidateErr := pc.AddICECandidate(candidate); candidateErr != nil {
+			pc.SendError(candidateErr)
+		}
+	case signaling.MessageTypePanZoom:
+		pz, err := pc.signalingCodec.DecodePanZoom(wsPacket.Message)
+		if err != nil {
+			pc.SendError(err)
+			return
+		}
+		pc.SetPanZoom(pz)
+	case signaling.MessageTypeBye:
+		pc.Close(errors.New("client said bye"))
+	case signaling.MessageTypeError:
+		logClient(wsPacket.ClientID, "client_error", errors.New(wsPacket.Message))
+	default:
+		pc.SendError(fmt.Errorf("%w: %s", signaling.ErrUnknownMessageType, signaling.MessageTypeName(wsPacket.MessageType)))
+	}
+}
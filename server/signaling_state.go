@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/signaling"
+	"github.com/MatthiasDeFre/webrtc-pc-server/transport"
+	"github.com/pion/webrtc/v3"
+)
+
+// setSignalingState must be called with signalingMux held
+func (pc *PeerConnection) setSignalingState(state signaling.SignalingState) {
+	if pc.signalingState != state {
+		logClient(pc.clientID, "signaling_state", fmt.Errorf("%s -> %s", pc.signalingState, state))
+	}
+	pc.signalingState = state
+	if state == signaling.Ready && pc.negotiationPending {
+		pc.negotiationPending = false
+		if err := pc.sendOffer(); err != nil {
+			logClient(pc.clientID, "renegotiation_failed", err)
+		}
+	}
+}
+
+// sendOffer must be called with signalingMux held
+func (pc *PeerConnection) sendOffer() error {
+	offer, err := pc.webrtcConnection.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if offer, err = pc.setLocalDescription(offer); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	pc.setSignalingState(signaling.Offer)
+	pc.SendWebsocketMessage(signaling.WebsocketPacket{ClientID: pc.clientID, MessageType: signaling.MessageTypeOffer, Message: string(payload)})
+	return nil
+}
+
+// setLocalDescription applies an offer or answer and returns the description that is sent to
+// the client. Pion rejects modified local descriptions so only the sent copy announces the RTX SSRC.
+func (pc *PeerConnection) setLocalDescription(desc webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if err := pc.webrtcConnection.SetLocalDescription(desc); err != nil {
+		return desc, err
+	}
+	for _, stream := range pc.streams {
+		if stream.rtxSSRC != 0 {
+			desc.SDP = transport.AddRTXSSRC(desc.SDP, uint32(stream.ssrc), uint32(stream.rtxSSRC))
+		}
+	}
+	return desc, nil
+}
+
+// updateRTX switches retransmissions to the RTX SSRC when the remote negotiated RTX for the
+// point cloud codec and back to the original SSRC otherwise
+func (pc *PeerConnection) updateRTX() {
+	if pc.rtx == nil {
+		return
+	}
+	for _, stream := range pc.streams {
+		if payloadType, ok := transport.RTXPayloadType(stream.sender.GetParameters().Codecs); ok {
+			pc.rtx.SetRTX(uint32(stream.ssrc), uint32(stream.rtxSSRC), payloadType)
+		} else {
+			pc.rtx.SetRTX(uint32(stream.ssrc), 0, 0)
+		}
+	}
+}
+
+// HandleHello sends an offer to a client that is waiting for one
+func (pc *PeerConnection) HandleHello() error {
+	pc.signalingMux.Lock()
+	defer pc.signalingMux.Unlock()
+	if pc.signalingState != signaling.Idle {
+		return signaling.UnexpectedMessageError(signaling.MessageTypeHello, pc.signalingState)
+	}
+	pc.setSignalingState(signaling.Hello)
+	return pc.sendOffer()
+}
+
+// errOfferNotRolledBack is returned when an offer failed after pion applied it, pion cannot roll
+// back a remote offer so the connection cannot negotiate anymore
+var errOfferNotRolledBack = errors.New("offer failed and cannot be rolled back")
+
+// HandleOffer answers an offer from the client. If the server has an offer outstanding (glare)
+// the server acts as the impolite peer and ignores the offer of the client, which has to answer
+// the offer of the server and offer again afterwards. When the offer cannot be answered before
+// pion applied it the state machine accepts a new offer, otherwise the client is closed.
+func (pc *PeerConnection) HandleOffer(offer webrtc.SessionDescription) error {
+	pc.signalingMux.Lock()
+	defer pc.signalingMux.Unlock()
+	if offer.Type != webrtc.SDPTypeOffer {
+		return fmt.Errorf("%w: expected offer, got %s", signaling.ErrMalformedMessage, offer.Type)
+	}
+	// State to return to when the offer cannot be answered
+	restore := pc.signalingState
+	switch pc.signalingState {
+	case signaling.Idle, signaling.Hello, signaling.Ready:
+	case signaling.Offer:
+		// Pion cannot roll back the local offer, the answer of the client resolves the glare
+		logClient(pc.clientID, "glare", errors.New("ignoring the client offer while the server offer is outstanding"))
+		return nil
+	default:
+		return signaling.UnexpectedMessageError(signaling.MessageTypeOffer, pc.signalingState)
+	}
+
+	answer, err := pc.createAnswer(offer)
+	if errors.Is(err, errOfferNotRolledBack) {
+		pc.negotiationPending = false
+		pc.setSignalingState(signaling.Finished)
+		// Close takes the signaling lock, run it once this handler has returned
+		go pc.Close(err)
+		return err
+	}
+	if err != nil {
+		pc.setSignalingState(restore)
+		return err
+	}
+	pc.setSignalingState(signaling.Answer)
+	pc.updateRTX()
+	pc.SendWebsocketMessage(signaling.WebsocketPacket{ClientID: pc.clientID, MessageType: signaling.MessageTypeAnswer, Message: answer})
+	// Candidates gathered for the answer are only sent after it
+	pc.releaseLocalCandidates()
+	pc.setSignalingState(signaling.Ready)
+	return nil
+}
+
+// createAnswer applies a remote offer and returns the encoded answer. Local candidates are held
+// until the caller releases them. On failure the candidates are released, and errOfferNotRolledBack
+// is returned when pion already applied the offer.
+func (pc *PeerConnection) createAnswer(offer webrtc.SessionDescription) (string, error) {
+	pc.candidatesMux.Lock()
+	hadRemoteDescription := pc.hasRemoteDescription
+	pc.candidatesMux.Unlock()
+	pc.holdLocalCandidates()
+	fail := func(err error) (string, error) {
+		pc.releaseLocalCandidates()
+		if pc.webrtcConnection.SignalingState() == webrtc.SignalingStateHaveRemoteOffer {
+			return "", fmt.Errorf("%w: %w", errOfferNotRolledBack, err)
+		}
+		pc.candidatesMux.Lock()
+		pc.hasRemoteDescription = hadRemoteDescription
+		pc.candidatesMux.Unlock()
+		return "", err
+	}
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return fail(err)
+	}
+	answer, err := pc.webrtcConnection.CreateAnswer(nil)
+	if err != nil {
+		return fail(err)
+	}
+	if answer, err = pc.setLocalDescription(answer); err != nil {
+		return fail(err)
+	}
+	payload, err := json.Marshal(answer)
+	if err != nil {
+		return fail(err)
+	}
+	return string(payload), nil
+}
+
+// HandleAnswer completes a negotiation that was started by the server
+func (pc *PeerConnection) HandleAnswer(answer webrtc.SessionDescription) error {
+	pc.signalingMux.Lock()
+	defer pc.signalingMux.Unlock()
+	if pc.signalingState != signaling.Offer {
+		return signaling.UnexpectedMessageError(signaling.MessageTypeAnswer, pc.signalingState)
+	}
+	if answer.Type != webrtc.SDPTypeAnswer {
+		return fmt.Errorf("%w: expected answer, got %s", signaling.ErrMalformedMessage, answer.Type)
+	}
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	pc.updateRTX()
+	pc.setSignalingState(signaling.Ready)
+	return nil
+}
+
+// Renegotiate starts a new offer/answer exchange, or queues one if a negotiation is in progress
+func (pc *PeerConnection) Renegotiate() error {
+	pc.signalingMux.Lock()
+	defer pc.signalingMux.Unlock()
+	switch pc.signalingState {
+	case signaling.Ready:
+		return pc.sendOffer()
+	case signaling.Finished:
+		return signaling.UnexpectedMessageError(signaling.MessageTypeOffer, pc.signalingState)
+	default:
+		pc.negotiationPending = true
+		return nil
+	}
+}
+
+// AddTrack adds a track mid-session and renegotiates
+func (pc *PeerConnection) AddTrack(track webrtc.TrackLocal) (*webrtc.RTPSender, error) {
+	rtpSender, err := pc.webrtcConnection.AddTrack(track)
+	if err != nil {
+		return nil, err
+	}
+	go readRTCP(rtpSender)
+	return rtpSender, pc.Renegotiate()
+}
+
+// RemoveTrack removes a track mid-session and renegotiates
+func (pc *PeerConnection) RemoveTrack(rtpSender *webrtc.RTPSender) error {
+	if err := pc.webrtcConnection.RemoveTrack(rtpSender); err != nil {
+		return err
+	}
+	return pc.Renegotiate()
+}
+
+// CloseSignaling moves the state machine to signaling.Finished, all further messages are rejected
+func (pc *PeerConnection) CloseSignaling() {
+	pc.signalingMux.Lock()
+	defer pc.signalingMux.Unlock()
+	pc.negotiationPending = false
+	pc.setSignalingState(signaling.Finished)
+}
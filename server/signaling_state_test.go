@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/signaling"
+	"github.com/MatthiasDeFre/webrtc-pc-server/transport"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// testClient is the client side of a signaling connection to a server PeerConnection
+type testClient struct {
+	t        *testing.T
+	conn     *websocket.Conn
+	codec    signaling.SignalingCodec
+	peer     *webrtc.PeerConnection
+	messages chan signaling.WebsocketPacket
+}
+
+// newTestClient connects a client to a server PeerConnection that offers as soon as it is created
+func newTestClient(t *testing.T) (*testClient, *PeerConnection) {
+	t.Helper()
+	serverPC := make(chan *PeerConnection, 1)
+	upgrader := websocket.Upgrader{}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		pc, err := NewPeerConnection(0, conn, DefaultServerConfig().PeerConnection, t.TempDir(), signaling.NewJSONSignalingCodec, wsHandlerMessageCbFunc, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := pc.Init(true); err != nil {
+			t.Error(err)
+		}
+		serverPC <- pc
+	}))
+	t.Cleanup(httpServer.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := transport.NewMediaEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := webrtc.NewAPI(webrtc.WithMediaEngine(m)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{
+		t:        t,
+		conn:     conn,
+		codec:    signaling.NewJSONSignalingCodec(0),
+		peer:     peer,
+		messages: make(chan signaling.WebsocketPacket, 64),
+	}
+	go c.read()
+
+	var pc *PeerConnection
+	select {
+	case pc = <-serverPC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not create the peer connection")
+	}
+	t.Cleanup(func() {
+		pc.Close(errors.New("test done"))
+		peer.Close()
+	})
+	return c, pc
+}
+
+func (c *testClient) read() {
+	defer close(c.messages)
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		wsPacket, err := c.codec.Decode(message)
+		if err != nil {
+			c.t.Errorf("client decode: %v", err)
+			return
+		}
+		c.messages <- wsPacket
+	}
+}
+
+// next returns the next offer, answer or error sent by the server, candidates are skipped.
+// ok is false when nothing arrives within timeout.
+func (c *testClient) next(timeout time.Duration) (signaling.WebsocketPacket, bool) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case wsPacket, open := <-c.messages:
+			if !open {
+				return wsPacket, false
+			}
+			if wsPacket.MessageType != signaling.MessageTypeCandidate {
+				return wsPacket, true
+			}
+		case <-deadline:
+			return signaling.WebsocketPacket{}, false
+		}
+	}
+}
+
+func (c *testClient) send(messageType uint64, desc webrtc.SessionDescription) {
+	c.t.Helper()
+	payload, err := json.Marshal(desc)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	message, err := c.codec.Encode(signaling.WebsocketPacket{MessageType: messageType, Message: string(payload)})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) expect(messageType uint64) webrtc.SessionDescription {
+	c.t.Helper()
+	wsPacket, ok := c.next(5 * time.Second)
+	if !ok {
+		c.t.Fatalf("no %s received", signaling.MessageTypeName(messageType))
+	}
+	if wsPacket.MessageType != messageType {
+		c.t.Fatalf("received %s %q, want %s", signaling.MessageTypeName(wsPacket.MessageType), wsPacket.Message, signaling.MessageTypeName(messageType))
+	}
+	var desc webrtc.SessionDescription
+	if err := json.Unmarshal([]byte(wsPacket.Message), &desc); err != nil {
+		c.t.Fatal(err)
+	}
+	return desc
+}
+
+func waitForSignalingState(t *testing.T, pc *PeerConnection, state signaling.SignalingState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pc.signalingMux.Lock()
+		current := pc.signalingState
+		pc.signalingMux.Unlock()
+		if current == state {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("signaling state = %s, want %s", current, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSignalingGlare(t *testing.T) {
+	c, pc := newTestClient(t)
+	serverOffer := c.expect(signaling.MessageTypeOffer)
+
+	// The client offers before it has seen the offer of the server
+	if _, err := c.peer.CreateDataChannel("glare", nil); err != nil {
+		t.Fatal(err)
+	}
+	clientOffer, err := c.peer.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.send(signaling.MessageTypeOffer, clientOffer)
+
+	// The client then answers the offer of the server, which keeps its own offer
+	if err := c.peer.SetRemoteDescription(serverOffer); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := c.peer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.peer.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	c.send(signaling.MessageTypeAnswer, answer)
+	waitForSignalingState(t, pc, signaling.Ready)
+	if wsPacket, ok := c.next(200 * time.Millisecond); ok {
+		t.Fatalf("server sent %s %q for the ignored offer", signaling.MessageTypeName(wsPacket.MessageType), wsPacket.Message)
+	}
+
+	// Offering again once the offer of the server is answered succeeds
+	clientOffer, err = c.peer.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.peer.SetLocalDescription(clientOffer); err != nil {
+		t.Fatal(err)
+	}
+	c.send(signaling.MessageTypeOffer, clientOffer)
+	if err := c.peer.SetRemoteDescription(c.expect(signaling.MessageTypeAnswer)); err != nil {
+		t.Fatal(err)
+	}
+	waitForSignalingState(t, pc, signaling.Ready)
+	if state := pc.webrtcConnection.SignalingState(); state != webrtc.SignalingStateStable {
+		t.Errorf("pion signaling state = %s, want stable", state)
+	}
+}
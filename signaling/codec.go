@@ -0,0 +1,252 @@
+// Package signaling implements the websocket signaling server and the message
+// formats that are spoken on it.
+package signaling
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+	"github.com/pion/webrtc/v3"
+)
+
+// SignalingVersion is the version of the JSON signaling envelope
+const SignalingVersion = 1
+
+// Message types, the numeric values are the ones used by the legacy format
+const (
+	MessageTypeHello     uint64 = 1
+	MessageTypeOffer     uint64 = 2
+	MessageTypeAnswer    uint64 = 3
+	MessageTypeCandidate uint64 = 4
+	MessageTypeBye       uint64 = 5
+	MessageTypePanZoom   uint64 = 10
+	MessageTypeError     uint64 = 255
+)
+
+var messageTypeNames = map[uint64]string{
+	MessageTypeHello:     "hello",
+	MessageTypeOffer:     "offer",
+	MessageTypeAnswer:    "answer",
+	MessageTypeCandidate: "candidate",
+	MessageTypeBye:       "bye",
+	MessageTypePanZoom:   "panzoom",
+	MessageTypeError:     "error",
+}
+
+var (
+	ErrMalformedMessage   = errors.New("signaling: malformed message")
+	ErrUnknownMessageType = errors.New("signaling: unknown message type")
+	ErrUnsupportedVersion = errors.New("signaling: unsupported version")
+	ErrMissingPayload     = errors.New("signaling: missing payload")
+	ErrClientIDMismatch   = errors.New("signaling: client id mismatch")
+	ErrOutOfOrderMessage  = errors.New("signaling: out of order sequence number")
+)
+
+// SignalingEnvelope is the JSON representation of a signaling message
+type SignalingEnvelope struct {
+	Version  int    `json:"version"`
+	Type     string `json:"type"`
+	ClientID uint64 `json:"client_id"`
+	// Sequence number, increasing per sender. 0 or a missing seq marks an unsequenced message
+	// that is not checked for order.
+	Seq     uint64          `json:"seq"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SignalingCodec converts between websocket frames and WebsocketPackets.
+// A codec instance belongs to a single connection because it keeps track of sequence numbers.
+type SignalingCodec interface {
+	Encode(wsPacket WebsocketPacket) ([]byte, error)
+	Decode(message []byte) (WebsocketPacket, error)
+	DecodePanZoom(message string) (pointcloud.PanZoom, error)
+	// EncodeCandidate returns false if the candidate cannot be expressed in this format
+	EncodeCandidate(candidate webrtc.ICECandidateInit) (string, bool)
+	DecodeCandidate(message string) (webrtc.ICECandidateInit, error)
+}
+
+type NewSignalingCodecFunc func(clientID uint64) SignalingCodec
+
+func MessageTypeName(messageType uint64) string {
+	if name, ok := messageTypeNames[messageType]; ok {
+		return name
+	}
+	return strconv.FormatUint(messageType, 10)
+}
+
+func messageTypeFromName(name string) (uint64, bool) {
+	for t, n := range messageTypeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// IsEndOfCandidates reports whether the candidate signals that no more candidates will follow
+func IsEndOfCandidates(candidate webrtc.ICECandidateInit) bool {
+	return candidate.Candidate == ""
+}
+
+// decodeCandidateInit accepts both a full ICECandidateInit object and a bare candidate string
+func decodeCandidateInit(message string) (webrtc.ICECandidateInit, error) {
+	var candidate webrtc.ICECandidateInit
+	if !strings.HasPrefix(strings.TrimSpace(message), "{") {
+		candidate.Candidate = message
+		return candidate, nil
+	}
+	if err := json.Unmarshal([]byte(message), &candidate); err != nil {
+		return candidate, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	return candidate, nil
+}
+
+// ------------------ Legacy ------------------
+
+// LegacySignalingCodec speaks the "clientID@type@message" format used by the Unity clients
+type LegacySignalingCodec struct{}
+
+func NewLegacySignalingCodec(clientID uint64) SignalingCodec {
+	return &LegacySignalingCodec{}
+}
+
+func (c *LegacySignalingCodec) Encode(wsPacket WebsocketPacket) ([]byte, error) {
+	return []byte(fmt.Sprintf("%d@%d@%s", wsPacket.ClientID, wsPacket.MessageType, wsPacket.Message)), nil
+}
+
+func (c *LegacySignalingCodec) Decode(message []byte) (WebsocketPacket, error) {
+	// The message itself can contain "@" (e.g. SDP), only split on the first two
+	v := strings.SplitN(string(message), "@", 3)
+	if len(v) != 3 {
+		return WebsocketPacket{}, fmt.Errorf("%w: expected 3 fields, got %d", ErrMalformedMessage, len(v))
+	}
+	clientID, err := strconv.ParseUint(v[0], 10, 64)
+	if err != nil {
+		return WebsocketPacket{}, fmt.Errorf("%w: invalid client id %q", ErrMalformedMessage, v[0])
+	}
+	messageType, err := strconv.ParseUint(v[1], 10, 64)
+	if err != nil {
+		return WebsocketPacket{}, fmt.Errorf("%w: invalid message type %q", ErrMalformedMessage, v[1])
+	}
+	return WebsocketPacket{ClientID: clientID, MessageType: messageType, Message: v[2]}, nil
+}
+
+func (c *LegacySignalingCodec) DecodePanZoom(message string) (pointcloud.PanZoom, error) {
+	var pz pointcloud.PanZoom
+	if err := binary.Read(bytes.NewBufferString(message), binary.LittleEndian, &pz); err != nil {
+		return pz, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	return pz, nil
+}
+
+// EncodeCandidate only sends the candidate string, the legacy clients have no notion of end-of-candidates
+func (c *LegacySignalingCodec) EncodeCandidate(candidate webrtc.ICECandidateInit) (string, bool) {
+	if IsEndOfCandidates(candidate) {
+		return "", false
+	}
+	return candidate.Candidate, true
+}
+
+func (c *LegacySignalingCodec) DecodeCandidate(message string) (webrtc.ICECandidateInit, error) {
+	return decodeCandidateInit(message)
+}
+
+// ------------------ JSON ------------------
+
+// JSONSignalingCodec speaks the versioned JSON envelope format
+type JSONSignalingCodec struct {
+	clientID    uint64
+	sendSeq     uint64
+	lastRecvSeq uint64
+}
+
+func NewJSONSignalingCodec(clientID uint64) SignalingCodec {
+	return &JSONSignalingCodec{clientID: clientID}
+}
+
+func (c *JSONSignalingCodec) Encode(wsPacket WebsocketPacket) ([]byte, error) {
+	c.sendSeq++
+	env := SignalingEnvelope{
+		Version:  SignalingVersion,
+		Type:     MessageTypeName(wsPacket.MessageType),
+		ClientID: wsPacket.ClientID,
+		Seq:      c.sendSeq,
+	}
+	// Messages that are already JSON documents (SDP, candidates, ...) are embedded as is,
+	// everything else is sent as a JSON string
+	trimmed := strings.TrimSpace(wsPacket.Message)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		env.Payload = json.RawMessage(trimmed)
+	} else if wsPacket.Message != "" {
+		payload, err := json.Marshal(wsPacket.Message)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = payload
+	}
+	return json.Marshal(env)
+}
+
+func (c *JSONSignalingCodec) Decode(message []byte) (WebsocketPacket, error) {
+	var env SignalingEnvelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		return WebsocketPacket{}, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	if env.Version != SignalingVersion {
+		return WebsocketPacket{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, env.Version)
+	}
+	messageType, ok := messageTypeFromName(env.Type)
+	if !ok {
+		return WebsocketPacket{}, fmt.Errorf("%w: %q", ErrUnknownMessageType, env.Type)
+	}
+	if env.ClientID != 0 && env.ClientID != c.clientID {
+		return WebsocketPacket{}, fmt.Errorf("%w: got %d, expected %d", ErrClientIDMismatch, env.ClientID, c.clientID)
+	}
+	if env.Seq != 0 {
+		if env.Seq <= c.lastRecvSeq {
+			return WebsocketPacket{}, fmt.Errorf("%w: got %d after %d", ErrOutOfOrderMessage, env.Seq, c.lastRecvSeq)
+		}
+		c.lastRecvSeq = env.Seq
+	}
+
+	wsPacket := WebsocketPacket{ClientID: c.clientID, MessageType: messageType, Seq: env.Seq}
+	if len(env.Payload) == 0 || string(env.Payload) == "null" {
+		if messageType != MessageTypeHello && messageType != MessageTypeBye {
+			return WebsocketPacket{}, fmt.Errorf("%w: %s", ErrMissingPayload, env.Type)
+		}
+		return wsPacket, nil
+	}
+	var s string
+	if err := json.Unmarshal(env.Payload, &s); err == nil {
+		wsPacket.Message = s
+	} else {
+		wsPacket.Message = string(env.Payload)
+	}
+	return wsPacket, nil
+}
+
+func (c *JSONSignalingCodec) DecodePanZoom(message string) (pointcloud.PanZoom, error) {
+	var pz pointcloud.PanZoom
+	if err := json.Unmarshal([]byte(message), &pz); err != nil {
+		return pz, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	return pz, nil
+}
+
+// EncodeCandidate sends the full candidate init, an empty candidate string signals end-of-candidates
+func (c *JSONSignalingCodec) EncodeCandidate(candidate webrtc.ICECandidateInit) (string, bool) {
+	payload, err := json.Marshal(candidate)
+	if err != nil {
+		return "", false
+	}
+	return string(payload), true
+}
+
+func (c *JSONSignalingCodec) DecodeCandidate(message string) (webrtc.ICECandidateInit, error) {
+	return decodeCandidateInit(message)
+}
@@ -0,0 +1,173 @@
+package signaling
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJSONSignalingCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet WebsocketPacket
+	}{
+		{"hello without payload", WebsocketPacket{ClientID: 3, MessageType: MessageTypeHello}},
+		{"bye without payload", WebsocketPacket{ClientID: 3, MessageType: MessageTypeBye}},
+		{"sdp object", WebsocketPacket{ClientID: 3, MessageType: MessageTypeOffer, Message: `{"type":"offer","sdp":"v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\n"}`}},
+		{"candidate object", WebsocketPacket{ClientID: 3, MessageType: MessageTypeCandidate, Message: `{"candidate":"candidate:1 1 udp 1 10.0.0.1 5000 typ host","sdpMid":"0","sdpMLineIndex":0,"usernameFragment":null}`}},
+		{"plain string", WebsocketPacket{ClientID: 3, MessageType: MessageTypeError, Message: "signaling: malformed message"}},
+		{"at signs in payload", WebsocketPacket{ClientID: 3, MessageType: MessageTypeError, Message: "user@host@domain"}},
+		{"quoted string", WebsocketPacket{ClientID: 3, MessageType: MessageTypeError, Message: `"quoted"`}},
+		{"invalid json object", WebsocketPacket{ClientID: 3, MessageType: MessageTypeError, Message: "{not json"}},
+		{"broadcast client id", WebsocketPacket{ClientID: 0, MessageType: MessageTypePanZoom, Message: `{"pan":1}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := NewJSONSignalingCodec(3)
+			receiver := NewJSONSignalingCodec(3)
+			for seq := uint64(1); seq <= 2; seq++ {
+				message, err := sender.Encode(tt.packet)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := receiver.Decode(message)
+				if err != nil {
+					t.Fatalf("decode %s: %v", message, err)
+				}
+				want := tt.packet
+				want.ClientID = 3
+				want.Seq = seq
+				if got != want {
+					t.Errorf("decoded %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONSignalingCodecDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// Messages decoded in order by one codec, only the last one is checked
+		messages []string
+		want     error
+	}{
+		{"not json", []string{`offer`}, ErrMalformedMessage},
+		{"truncated", []string{`{"version":1,"type":"hello"`}, ErrMalformedMessage},
+		{"missing version", []string{`{"type":"hello","client_id":3}`}, ErrUnsupportedVersion},
+		{"future version", []string{`{"version":2,"type":"hello","client_id":3}`}, ErrUnsupportedVersion},
+		{"unknown type", []string{`{"version":1,"type":"subscribe","client_id":3}`}, ErrUnknownMessageType},
+		{"numeric type", []string{`{"version":1,"type":"2","client_id":3}`}, ErrUnknownMessageType},
+		{"other client", []string{`{"version":1,"type":"hello","client_id":4}`}, ErrClientIDMismatch},
+		{"offer without payload", []string{`{"version":1,"type":"offer","client_id":3}`}, ErrMissingPayload},
+		{"null payload", []string{`{"version":1,"type":"candidate","client_id":3,"payload":null}`}, ErrMissingPayload},
+		{
+			name: "repeated seq",
+			messages: []string{
+				`{"version":1,"type":"hello","client_id":3,"seq":5}`,
+				`{"version":1,"type":"hello","client_id":3,"seq":5}`,
+			},
+			want: ErrOutOfOrderMessage,
+		},
+		{
+			name: "decreasing seq",
+			messages: []string{
+				`{"version":1,"type":"hello","client_id":3,"seq":5}`,
+				`{"version":1,"type":"hello","client_id":3,"seq":4}`,
+			},
+			want: ErrOutOfOrderMessage,
+		},
+		{
+			name: "unsequenced between sequenced",
+			messages: []string{
+				`{"version":1,"type":"hello","client_id":3,"seq":5}`,
+				`{"version":1,"type":"hello","client_id":3}`,
+				`{"version":1,"type":"hello","client_id":3,"seq":6}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewJSONSignalingCodec(3)
+			var err error
+			for _, message := range tt.messages {
+				_, err = c.Decode([]byte(message))
+			}
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("err = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacySignalingCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet WebsocketPacket
+	}{
+		{"empty message", WebsocketPacket{ClientID: 3, MessageType: MessageTypeHello}},
+		{"sdp", WebsocketPacket{ClientID: 3, MessageType: MessageTypeOffer, Message: `{"type":"offer","sdp":"v=0\r\n"}`}},
+		{"at signs in message", WebsocketPacket{ClientID: 3, MessageType: MessageTypeCandidate, Message: "a@b@@c@"}},
+		{"unknown type", WebsocketPacket{ClientID: 3, MessageType: 42, Message: "x"}},
+		{"large client id", WebsocketPacket{ClientID: 1<<64 - 1, MessageType: MessageTypeBye}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLegacySignalingCodec(3)
+			message, err := c.Encode(tt.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Decode(message)
+			if err != nil {
+				t.Fatalf("decode %q: %v", message, err)
+			}
+			if got != tt.packet {
+				t.Errorf("decoded %+v, want %+v", got, tt.packet)
+			}
+		})
+	}
+}
+
+func TestLegacySignalingCodecDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    WebsocketPacket
+		wantErr bool
+	}{
+		{"offer", "3@2@sdp", WebsocketPacket{ClientID: 3, MessageType: MessageTypeOffer, Message: "sdp"}, false},
+		{"at sign in payload", "3@4@candidate@host", WebsocketPacket{ClientID: 3, MessageType: MessageTypeCandidate, Message: "candidate@host"}, false},
+		{"trailing at sign", "3@1@", WebsocketPacket{ClientID: 3, MessageType: MessageTypeHello}, false},
+		{"empty", "", WebsocketPacket{}, true},
+		{"one field", "3", WebsocketPacket{}, true},
+		{"two fields", "3@2", WebsocketPacket{}, true},
+		{"empty client id", "@2@sdp", WebsocketPacket{}, true},
+		{"invalid client id", "x@2@sdp", WebsocketPacket{}, true},
+		{"negative client id", "-1@2@sdp", WebsocketPacket{}, true},
+		{"invalid type", "3@offer@sdp", WebsocketPacket{}, true},
+		{"type overflow", "3@18446744073709551616@sdp", WebsocketPacket{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewLegacySignalingCodec(3).Decode([]byte(tt.message))
+			if tt.wantErr {
+				if !errors.Is(err, ErrMalformedMessage) {
+					t.Errorf("err = %v, want %v", err, ErrMalformedMessage)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
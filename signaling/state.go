@@ -0,0 +1,50 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SignalingState is the negotiation state of a single client
+type SignalingState int
+
+const (
+	// Idle no negotiation has happened yet
+	Idle SignalingState = iota
+	// Hello the client asked the server to send an offer
+	Hello
+	// Offer a local offer was sent, waiting for the answer
+	Offer
+	// Answer a remote offer was received, the answer is being created
+	Answer
+	// Ready negotiation is complete, renegotiation can start from here
+	Ready
+	// Finished the connection is closed, all messages are rejected
+	Finished
+)
+
+var ErrUnexpectedMessage = errors.New("signaling: unexpected message")
+
+func (s SignalingState) String() string {
+	switch s {
+	case Idle:
+		return "idle"
+	case Hello:
+		return "hello"
+	case Offer:
+		return "offer"
+	case Answer:
+		return "answer"
+	case Ready:
+		return "ready"
+	case Finished:
+		return "finished"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// UnexpectedMessageError is returned when a message does not fit the current state
+func UnexpectedMessageError(messageType uint64, state SignalingState) error {
+	return fmt.Errorf("%w: %s in state %s", ErrUnexpectedMessage, MessageTypeName(messageType), state)
+}
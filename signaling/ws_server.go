@@ -0,0 +1,64 @@
+package signaling
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+type WebsocketPacket struct {
+	ClientID    uint64
+	MessageType uint64
+	Message     string
+	Seq         uint64
+}
+
+type NewUserCallback func(*websocket.Conn)
+
+type WebsocketHandler struct {
+	newUserCb  NewUserCallback
+	upgrader   *websocket.Upgrader
+	httpServer *http.Server
+}
+
+// TODO USE websockets for control data
+func NewWSServer(addr string, newUserCb NewUserCallback) (*WebsocketHandler, error) {
+	upgrader := &websocket.Upgrader{}
+	wsServer := &WebsocketHandler{newUserCb: newUserCb, upgrader: upgrader}
+	// Every server gets its own mux so several can run in one process
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", wsServer.getNewClientCbFunc)
+	wsServer.httpServer = &http.Server{Addr: addr, Handler: mux}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go wsServer.httpServer.Serve(listener)
+	return wsServer, nil
+}
+
+// Close stops accepting new clients
+func (ws *WebsocketHandler) Close() error {
+	return ws.httpServer.Close()
+}
+
+// Shutdown stops accepting new clients, connections that were already upgraded
+// to a websocket are not affected
+func (ws *WebsocketHandler) Shutdown(ctx context.Context) error {
+	return ws.httpServer.Shutdown(ctx)
+}
+
+func (ws *WebsocketHandler) getNewClientCbFunc(w http.ResponseWriter, r *http.Request) {
+	c, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Print("upgrade:", err)
+		return
+	}
+	ws.newUserCb(c)
+	// Add peer connection
+	// TODO Change to peer closing it
+
+}
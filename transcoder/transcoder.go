@@ -1,30 +1,30 @@
-package main
+// Package transcoder provides the frame sources (files, proxy) and encodes their frames per client.
+package transcoder
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
-)
 
-type Frame struct {
-	ClientID uint32
-	FrameLen uint32
-	FrameNr  uint32
-	Data     []byte
-}
+	"github.com/MatthiasDeFre/webrtc-pc-server/layered"
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+	"github.com/MatthiasDeFre/webrtc-pc-server/proxy"
+)
 
 type Transcoder interface {
 	UpdateBitrate(bitrate uint32)
 	UpdateProjection()
-	EncodeFrame(data []byte, framecounter uint32, bitrate uint32) *Frame
+	// EncodeFrame encodes a frame for a client, viewport is the predicted viewport of the viewer
+	// or nil when the client did not report a pose and selection holds the layers the client was
+	// sent before. The data of the frame can be shared with other clients and must not be modified.
+	EncodeFrame(data []byte, framecounter uint32, captureTime time.Time, bitrate uint32, viewport *pointcloud.Viewport, selection *layered.LayerSelection) *pointcloud.Frame
 	IsReady() bool
 	GetEstimatedBitrate() uint32
 	GetFrameCounter() uint32
-	NextFrame() (uint32, []byte)
+	// NextFrame blocks until the next frame is available and returns it with its capture time
+	NextFrame() (uint32, []byte, time.Time)
 }
 
 func readFiles(directory string) ([][]byte, []int64, error) {
@@ -57,31 +57,24 @@ type TranscoderFiles struct {
 	frameCounter     uint32
 	isReady          bool
 	fileCounter      uint32
-	lEnc             *LayeredEncoder
+	lEnc             *layered.LayeredEncoder
 	estimatedBitrate uint32
 	prevFrameTime    int64
 	frameRate        uint32
 
 	frames [][]byte
+	// Frames encoded from the current source frame, shared by the clients that select the same layers
+	cache *layered.EncodeCache
 }
 
-func (f *Frame) Bytes() []byte {
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.BigEndian, f.ClientID)
-	binary.Write(buf, binary.BigEndian, f.FrameLen)
-	binary.Write(buf, binary.BigEndian, f.FrameNr)
-	binary.Write(buf, binary.BigEndian, f.Data)
-	return buf.Bytes()
-}
-
-func NewTranscoderFile(contentDirectory string, frameRate uint32) *TranscoderFiles {
+func NewTranscoderFile(contentDirectory string, frameRate uint32, policy layered.LayerPolicy) *TranscoderFiles {
 	//fBytes, _ := ReadBinaryFiles(contentDirectory)
 	frames, _, err := readFiles(contentDirectory)
 	if err != nil {
 		fmt.Println("Error reading layer_0:", err)
 	}
 
-	return &TranscoderFiles{0, true, 0, NewLayeredEncoder(), 0, 0, frameRate, frames}
+	return &TranscoderFiles{0, true, 0, layered.NewLayeredEncoder(frameRate, policy), 0, 0, frameRate, frames, layered.NewEncodeCache()}
 }
 
 func (t *TranscoderFiles) UpdateBitrate(bitrate uint32) {
@@ -93,27 +86,31 @@ func (t *TranscoderFiles) UpdateProjection() {
 	// Do nothing
 }
 
-func (t *TranscoderFiles) NextFrame() (uint32, []byte) {
+func (t *TranscoderFiles) NextFrame() (uint32, []byte, time.Time) {
 	sleepTime := int64(1000/t.frameRate) - (time.Now().UnixMilli() - t.prevFrameTime)
 	if sleepTime > 0 {
 		time.Sleep(time.Duration(sleepTime) * time.Millisecond)
 	}
-	t.prevFrameTime = time.Now().UnixMilli()
+	playbackTime := time.Now()
+	t.prevFrameTime = playbackTime.UnixMilli()
 	t.frameCounter++
 	currentCounter := t.fileCounter
 	t.fileCounter = (t.fileCounter + 1) % uint32(len(t.frames))
-	return t.frameCounter, t.frames[currentCounter]
+	return t.frameCounter, t.frames[currentCounter], playbackTime
 }
 
-func (t *TranscoderFiles) EncodeFrame(data []byte, framecounter uint32, bitrate uint32) *Frame {
+func (t *TranscoderFiles) EncodeFrame(data []byte, framecounter uint32, captureTime time.Time, bitrate uint32, viewport *pointcloud.Viewport, selection *layered.LayerSelection) *pointcloud.Frame {
 
 	//transcodedData := t.lEnc.EncodeMultiFrame(data)
 
-	transcodedData := t.lEnc.EncodeMultiFrame(data, bitrate)
-	if data == nil {
+	t.cache.Reset(framecounter)
+	transcodedData := t.lEnc.Encode(data, bitrate, viewport, selection, t.cache)
+	// Nothing fits the budget or the frame is too far away to be sent
+	if len(transcodedData) == 0 {
 		return nil
 	}
-	rFrame := Frame{0, uint32(len(transcodedData)), framecounter, transcodedData}
+	rFrame := pointcloud.Frame{FrameLen: uint32(len(transcodedData)), FrameNr: framecounter, Data: transcodedData, CaptureTime: captureTime}
+	rFrame.Layers, _ = layered.ParseLayers(transcodedData)
 	return &rFrame
 }
 
@@ -130,15 +127,17 @@ func (t *TranscoderFiles) GetFrameCounter() uint32 {
 }
 
 type TranscoderRemote struct {
-	proxyConn        *ProxyConnection
+	proxyConn        *proxy.ProxyConnection
 	frameCounter     uint32
 	isReady          bool
-	lEnc             *LayeredEncoder
+	lEnc             *layered.LayeredEncoder
 	estimatedBitrate uint32
+	// Frames encoded from the current source frame, shared by the clients that select the same layers
+	cache *layered.EncodeCache
 }
 
-func NewTranscoderRemote(proxy_con *ProxyConnection) *TranscoderRemote {
-	return &TranscoderRemote{proxy_con, 0, true, NewLayeredEncoder(), 0}
+func NewTranscoderRemote(proxy_con *proxy.ProxyConnection, frameRate uint32, policy layered.LayerPolicy) *TranscoderRemote {
+	return &TranscoderRemote{proxy_con, 0, true, layered.NewLayeredEncoder(frameRate, policy), 0, layered.NewEncodeCache()}
 }
 
 func (t *TranscoderRemote) UpdateBitrate(bitrate uint32) {
@@ -149,16 +148,19 @@ func (t *TranscoderRemote) UpdateBitrate(bitrate uint32) {
 func (t *TranscoderRemote) UpdateProjection() {
 	// Do nothing
 }
-func (t *TranscoderRemote) NextFrame() (uint32, []byte) {
-	return proxyConn.NextFrame(0)
+func (t *TranscoderRemote) NextFrame() (uint32, []byte, time.Time) {
+	return t.proxyConn.NextFrame(0)
 }
 
-func (t *TranscoderRemote) EncodeFrame(data []byte, framecounter uint32, bitrate uint32) *Frame {
-	transcodedData := t.lEnc.EncodeMultiFrame(data, bitrate)
-	if data == nil {
+func (t *TranscoderRemote) EncodeFrame(data []byte, framecounter uint32, captureTime time.Time, bitrate uint32, viewport *pointcloud.Viewport, selection *layered.LayerSelection) *pointcloud.Frame {
+	t.cache.Reset(framecounter)
+	transcodedData := t.lEnc.Encode(data, bitrate, viewport, selection, t.cache)
+	// Nothing fits the budget or the frame is too far away to be sent
+	if len(transcodedData) == 0 {
 		return nil
 	}
-	rFrame := Frame{0, uint32(len(transcodedData)), framecounter, transcodedData}
+	rFrame := pointcloud.Frame{FrameLen: uint32(len(transcodedData)), FrameNr: framecounter, Data: transcodedData, CaptureTime: captureTime}
+	rFrame.Layers, _ = layered.ParseLayers(transcodedData)
 	return &rFrame
 }
 
@@ -175,14 +177,14 @@ func (t *TranscoderRemote) GetFrameCounter() uint32 {
 // INDI TRANSCODER
 
 type TranscoderRemoteIndi struct {
-	proxyConn        *ProxyConnection
+	proxyConn        *proxy.ProxyConnection
 	frameCounter     uint32
 	isReady          bool
 	estimatedBitrate uint32
 	clientID         uint32
 }
 
-func NewTranscoderRemoteIndi(proxy_con *ProxyConnection, clientID uint32) *TranscoderRemoteIndi {
+func NewTranscoderRemoteIndi(proxy_con *proxy.ProxyConnection, clientID uint32) *TranscoderRemoteIndi {
 	return &TranscoderRemoteIndi{proxy_con, 0, true, 0, clientID}
 }
 
@@ -193,15 +195,17 @@ func (t *TranscoderRemoteIndi) UpdateBitrate(bitrate uint32) {
 func (t *TranscoderRemoteIndi) UpdateProjection() {
 	// Do nothing
 }
-func (t *TranscoderRemoteIndi) NextFrame() (uint32, []byte) {
-	return proxyConn.NextFrame(t.clientID)
+func (t *TranscoderRemoteIndi) NextFrame() (uint32, []byte, time.Time) {
+	return t.proxyConn.NextFrame(t.clientID)
 }
 
-func (t *TranscoderRemoteIndi) EncodeFrame(data []byte, framecounter uint32, bitrate uint32) *Frame {
+func (t *TranscoderRemoteIndi) EncodeFrame(data []byte, framecounter uint32, captureTime time.Time, bitrate uint32, viewport *pointcloud.Viewport, selection *layered.LayerSelection) *pointcloud.Frame {
 	if data == nil {
 		return nil
 	}
-	rFrame := Frame{t.clientID, uint32(len(data)), framecounter, data}
+	rFrame := pointcloud.Frame{ClientID: t.clientID, FrameLen: uint32(len(data)), FrameNr: framecounter, Data: data, CaptureTime: captureTime}
+	// Frames of the capture application are multi-layer frames as well
+	rFrame.Layers, _ = layered.ParseLayers(data)
 	return &rFrame
 }
 
@@ -216,17 +220,18 @@ func (t *TranscoderRemoteIndi) GetFrameCounter() uint32 {
 }
 
 type TranscoderDummy struct {
-	proxy_con        *ProxyConnection
+	proxy_con        *proxy.ProxyConnection
 	frameCounter     uint32
 	isReady          bool
 	bitrate          uint32
 	isFixed          bool
 	isDummy          bool
 	estimatedBitrate uint32
+	frameRate        uint32
 }
 
-func NewTranscoderDummy(proxy_con *ProxyConnection, bitrate uint32, isFixed bool, isDummy bool) *TranscoderDummy {
-	return &TranscoderDummy{proxy_con, 0, true, bitrate, isFixed, isDummy, 0}
+func NewTranscoderDummy(proxy_con *proxy.ProxyConnection, bitrate uint32, isFixed bool, isDummy bool, frameRate uint32) *TranscoderDummy {
+	return &TranscoderDummy{proxy_con, 0, true, bitrate, isFixed, isDummy, 0, frameRate}
 }
 
 func (t *TranscoderDummy) UpdateBitrate(bitrate uint32) {
@@ -241,14 +246,14 @@ func (t *TranscoderDummy) UpdateProjection() {
 	// Do nothing
 }
 
-func (t *TranscoderDummy) EncodeFrame(data []byte, framecounter uint32, bitrate uint32) *Frame {
+func (t *TranscoderDummy) EncodeFrame(data []byte, framecounter uint32, captureTime time.Time, bitrate uint32, viewport *pointcloud.Viewport, selection *layered.LayerSelection) *pointcloud.Frame {
 
 	if t.isDummy {
 		return nil
 	}
 	//	//println(100000 / 8 / t.n_tiles)
-	transcodedData := make([]byte, uint32(float64(t.bitrate/8/30)))
-	rFrame := Frame{0, uint32(len(transcodedData)), framecounter, transcodedData}
+	transcodedData := make([]byte, uint32(float64(t.bitrate/8/t.frameRate)))
+	rFrame := pointcloud.Frame{FrameLen: uint32(len(transcodedData)), FrameNr: framecounter, Data: transcodedData, CaptureTime: captureTime}
 	t.frameCounter++
 	return &rFrame
 }
@@ -262,6 +267,6 @@ func (t *TranscoderDummy) GetEstimatedBitrate() uint32 {
 func (t *TranscoderDummy) GetFrameCounter() uint32 {
 	return t.frameCounter
 }
-func (t *TranscoderDummy) NextFrame() (uint32, []byte) {
-	return t.frameCounter, make([]byte, uint32(float64(t.bitrate/8/30)))
+func (t *TranscoderDummy) NextFrame() (uint32, []byte, time.Time) {
+	return t.frameCounter, make([]byte, uint32(float64(t.bitrate/8/t.frameRate))), time.Now()
 }
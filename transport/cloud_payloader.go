@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+// AV1Payloader payloads AV1 packets
+type PointCloudPayloader struct {
+	FrameCounter uint32
+	// Amount of frame data per packet, at most MaxFragmentSize
+	FragmentSize uint32
+	// Wire format of the fragments, FramePacketVersion or FramePacketVersionLegacy
+	Version uint8
+	// Layers of the frame that is being payloaded, used to select the FEC protection ratio
+	Layers []pointcloud.Layer
+	// Parity packets per fragment for every layer, nil disables FEC. Legacy fragments are never protected.
+	FECRatios []float64
+}
+
+// Payload fragments a frame across one or more payloads of at most mtu bytes
+func (p *PointCloudPayloader) Payload(mtu uint16, payload []byte) (payloads [][]byte) {
+	frameNr := p.FrameCounter
+	payloadDataOffset := uint32(0)
+	payloadLen := uint32(len(payload))
+	payloadRemaining := payloadLen
+	fragmentSize := p.fragmentSize(mtu)
+	if fragmentSize == 0 {
+		log.Printf("frame=%d event=payload_failed reason=%q", frameNr, fmt.Sprintf("mtu %d is too small", mtu))
+		return nil
+	}
+	for payloadRemaining > 0 {
+		currentFragmentSize := fragmentSize
+		if payloadRemaining < currentFragmentSize {
+			currentFragmentSize = payloadRemaining
+		}
+		packet := NewFramePacket(frameNr, payloadLen, currentFragmentSize, payloadDataOffset, payload)
+		b, err := packet.Marshal(p.Version)
+		if err != nil {
+			log.Printf("frame=%d event=payload_failed reason=%q", frameNr, err)
+			return nil
+		}
+		payloads = append(payloads, b)
+		payloadDataOffset += currentFragmentSize
+		payloadRemaining -= currentFragmentSize
+	}
+	if p.Version != FramePacketVersionLegacy {
+		for _, fec := range NewFECPackets(frameNr, payload, fragmentSize, p.Layers, p.FECRatios) {
+			payloads = append(payloads, fec.Marshal())
+		}
+	}
+	//p.frameCounter++
+	return payloads
+}
+
+// fragmentSize returns the amount of frame data that fits in a payload of at most mtu bytes.
+// Legacy fragments always have the same size so only the compact format adapts to the mtu.
+func (p *PointCloudPayloader) fragmentSize(mtu uint16) uint32 {
+	fragmentSize := p.FragmentSize
+	if p.Version == FramePacketVersionLegacy {
+		return fragmentSize
+	}
+	if int(mtu) <= FramePacketHeaderSize {
+		return 0
+	}
+	if available := uint32(mtu) - FramePacketHeaderSize; available < fragmentSize {
+		fragmentSize = available
+	}
+	return fragmentSize
+}
+
+func NewPointCloudPayloader(fragmentSize uint32, version uint8) *PointCloudPayloader {
+	if fragmentSize == 0 || fragmentSize > MaxFragmentSize {
+		fragmentSize = MaxFragmentSize
+	}
+	if version != FramePacketVersionLegacy {
+		version = FramePacketVersion
+	}
+	return &PointCloudPayloader{FragmentSize: fragmentSize, Version: version}
+}
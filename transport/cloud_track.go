@@ -0,0 +1,210 @@
+// Package transport packetizes point cloud frames and sends them over WebRTC.
+package transport
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// TrackLocalStaticRTP  is a TrackLocal that has a pre-set codec and accepts RTP Packets.
+// If you wish to send a media.Sample use TrackLocalStaticSample
+type TrackLocalCloudRTP struct {
+	packetizer   rtp.Packetizer
+	sequencer    rtp.Sequencer
+	rtpTrack     *webrtc.TrackLocalStaticRTP
+	clockRate    float64
+	currentFrame uint32
+	pcPayloader  *PointCloudPayloader
+	fragmentSize uint32
+	version      uint8
+	mtu          uint16
+	fecRatios    []float64
+
+	// Frames of the shared transcoder are written concurrently
+	writeMux sync.Mutex
+	// RTP timestamp and capture time of the first frame, later timestamps are derived from them
+	firstTimestamp   uint32
+	firstCaptureTime time.Time
+}
+
+// NewTrackLocalStaticSample returns a TrackLocalStaticSample
+func NewTrackLocalCloudRTP(c webrtc.RTPCodecCapability, id, streamID string, options ...func(*webrtc.TrackLocalStaticRTP)) (*TrackLocalCloudRTP, error) {
+	rtpTrack, err := webrtc.NewTrackLocalStaticRTP(c, id, streamID, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &TrackLocalCloudRTP{
+		rtpTrack:     rtpTrack,
+		fragmentSize: MaxFragmentSize,
+		version:      FramePacketVersion,
+		mtu:          DefaultMTU,
+	}, nil
+}
+
+// SetFragmentSize changes the amount of frame data per packet, has to be called before Bind
+func (s *TrackLocalCloudRTP) SetFragmentSize(fragmentSize uint32) {
+	s.fragmentSize = fragmentSize
+}
+
+// SetMTU changes the maximum size of the RTP packets including header and extensions,
+// has to be called before Bind
+func (s *TrackLocalCloudRTP) SetMTU(mtu uint16) {
+	s.mtu = mtu
+}
+
+// SetFECRatios enables XOR parity packets, ratios[i] is the number of parity packets per
+// fragment of layer i. Has to be called before Bind.
+func (s *TrackLocalCloudRTP) SetFECRatios(ratios []float64) {
+	s.fecRatios = ratios
+}
+
+// SetPacketVersion changes the wire format of the fragments, has to be called before Bind
+func (s *TrackLocalCloudRTP) SetPacketVersion(version uint8) {
+	s.version = version
+}
+
+// Bind is called by the PeerConnection after negotiation is complete
+// This asserts that the code requested is supported by the remote peer.
+// If so it setups all the state (SSRC and PayloadType) to have a call
+func (s *TrackLocalCloudRTP) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := s.rtpTrack.Bind(t)
+	if err != nil {
+		return codec, err
+	}
+
+	// We only need one packetizer
+	if s.packetizer != nil {
+		return codec, nil
+	}
+	s.pcPayloader = NewPointCloudPayloader(s.fragmentSize, s.version)
+	s.pcPayloader.FECRatios = s.fecRatios
+	s.sequencer = rtp.NewRandomSequencer()
+	s.packetizer = rtp.NewPacketizer(
+		// The packetizer only subtracts the RTP header, header extensions are added afterwards
+		s.mtu-(RTPPacketOverhead-12),
+		0, // Value is handled when writing
+		0, // Value is handled when writing
+		s.pcPayloader,
+		s.sequencer,
+		codec.ClockRate,
+	)
+
+	s.clockRate = float64(codec.RTPCodecCapability.ClockRate)
+	return codec, err
+}
+
+// Unbind implements the teardown logic when the track is no longer needed. This happens
+// because a track has been stopped.
+func (s *TrackLocalCloudRTP) Unbind(t webrtc.TrackLocalContext) error {
+	return s.rtpTrack.Unbind(t)
+}
+
+// ID is the unique identifier for this Track. This should be unique for the
+// stream, but doesn't have to globally unique. A common example would be 'audio' or 'video'
+// and StreamID would be 'desktop' or 'webcam'
+func (s *TrackLocalCloudRTP) ID() string { return s.rtpTrack.ID() }
+
+// StreamID is the group this track belongs too. This must be unique
+func (s *TrackLocalCloudRTP) StreamID() string { return s.rtpTrack.StreamID() }
+
+// RID is the RTP stream identifier
+func (s *TrackLocalCloudRTP) RID() string { return s.rtpTrack.RID() }
+
+// Kind controls if this TrackLocal is audio or video
+func (s *TrackLocalCloudRTP) Kind() webrtc.RTPCodecType { return s.rtpTrack.Kind() }
+
+// Codec gets the Codec of the track
+func (s *TrackLocalCloudRTP) Codec() webrtc.RTPCodecCapability {
+	return s.rtpTrack.Codec()
+}
+
+// WriteFrame packetizes and sends a frame. The RTP timestamp is derived from the capture time
+// of the frame relative to the first frame and the marker bit is set on the last fragment.
+func (s *TrackLocalCloudRTP) WriteFrame(frame *pointcloud.Frame) error {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+	p := s.packetizer
+	if p == nil {
+		return nil
+	}
+
+	captureTime := frame.CaptureTime
+	if captureTime.IsZero() {
+		captureTime = time.Now()
+	}
+	s.pcPayloader.FrameCounter = frame.FrameNr
+	s.pcPayloader.Layers = frame.Layers
+	packets := p.Packetize(frame.Data, 0)
+	if len(packets) == 0 {
+		return nil
+	}
+	if s.firstCaptureTime.IsZero() {
+		s.firstCaptureTime = captureTime
+		s.firstTimestamp = packets[0].Timestamp
+	}
+	elapsed := captureTime.Sub(s.firstCaptureTime).Seconds()
+	timestamp := s.firstTimestamp + uint32(int64(elapsed*s.clockRate))
+
+	last := lastFragment(packets, s.pcPayloader.Version)
+	writeErrs := []error{}
+	for i, packet := range packets {
+		packet.Timestamp = timestamp
+		packet.Marker = i == last
+		if err := s.rtpTrack.WriteRTP(packet); err != nil {
+			writeErrs = append(writeErrs, err)
+		}
+	}
+	return errors.Join(writeErrs...)
+}
+
+// lastFragment returns the index of the packet carrying the last fragment of a frame, parity
+// packets follow the fragments and never carry the marker bit
+func lastFragment(packets []*rtp.Packet, version uint8) int {
+	last := len(packets) - 1
+	if version == FramePacketVersionLegacy {
+		return last
+	}
+	for last > 0 && IsFECPacket(packets[last].Payload) {
+		last--
+	}
+	return last
+}
+
+// PointCloudCodecCapability is the codec used for point cloud tracks
+func PointCloudCodecCapability() webrtc.RTPCodecCapability {
+	videoRTCPFeedback := []webrtc.RTCPFeedback{
+		{Type: "goog-remb", Parameter: ""},
+		{Type: "ccm", Parameter: "fir"},
+		{Type: "nack", Parameter: ""},
+		{Type: "nack", Parameter: "pli"},
+	}
+
+	return webrtc.RTPCodecCapability{
+		MimeType:     "video/pcm",
+		ClockRate:    90000,
+		Channels:     0,
+		SDPFmtpLine:  "",
+		RTCPFeedback: videoRTCPFeedback,
+	}
+}
+
+// NewMediaEngine returns a media engine with the default codecs and the point cloud codec,
+// feedback and header extensions are registered by the interceptors that need them
+func NewMediaEngine() (*webrtc.MediaEngine, error) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: PointCloudCodecCapability(),
+		PayloadType:        PointCloudPayloadType,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestLastFragment(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   uint8
+		frameLen  uint32
+		fecRatios []float64
+		// Index of the packet that carries the marker bit
+		want int
+	}{
+		{"single fragment", FramePacketVersion, 10, nil, 0},
+		{"without fec", FramePacketVersion, 50, nil, 2},
+		{"with fec", FramePacketVersion, 50, []float64{1}, 2},
+		{"single fragment with fec", FramePacketVersion, 10, []float64{1}, 0},
+		{"legacy", FramePacketVersionLegacy, 50, []float64{1}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloader := NewPointCloudPayloader(20, tt.version)
+			payloader.FECRatios = tt.fecRatios
+			var packets []*rtp.Packet
+			for _, payload := range payloader.Payload(1200, testFrameData(tt.frameLen)) {
+				packets = append(packets, &rtp.Packet{Payload: payload})
+			}
+			if got := lastFragment(packets, tt.version); got != tt.want {
+				t.Errorf("marker on packet %d of %d, want %d", got, len(packets), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// DataChannelLabel is the label of the data channel that carries point cloud frames
+	DataChannelLabel = "pointcloud"
+	// DefaultDataChannelFragmentSize keeps every message at 16 KiB, the size all browsers accept
+	DefaultDataChannelFragmentSize = 16*1024 - FramePacketHeaderSize
+	// MaxDataChannelMessageSize is the largest message pion sends without an explicit
+	// max-message-size from the remote
+	MaxDataChannelMessageSize = 65536
+
+	// Frames are dropped while more than this many bytes are queued on the channel, an
+	// unreliable channel would otherwise keep growing its buffer when the path is congested
+	dataChannelMaxBufferedAmount = 4 * 1024 * 1024
+)
+
+var ErrDataChannelCongested = errors.New("data channel buffer is full")
+
+// FrameWriter sends point cloud frames to a client, it is implemented by both the RTP track
+// and the data channel transport
+type FrameWriter interface {
+	WriteFrame(frame *pointcloud.Frame) error
+}
+
+// DataChannelFrameWriter sends frames as compact FramePackets, one per data channel message, so
+// clients that cannot consume the point cloud RTP payload (e.g. browsers) can receive them
+type DataChannelFrameWriter struct {
+	channel      *webrtc.DataChannel
+	fragmentSize uint32
+}
+
+func NewDataChannelFrameWriter(channel *webrtc.DataChannel, fragmentSize uint32) *DataChannelFrameWriter {
+	return &DataChannelFrameWriter{channel: channel, fragmentSize: fragmentSize}
+}
+
+// WriteFrame sends the fragments of a frame, frames written before the channel is open are dropped
+func (w *DataChannelFrameWriter) WriteFrame(frame *pointcloud.Frame) error {
+	if w.channel.ReadyState() != webrtc.DataChannelStateOpen {
+		return nil
+	}
+	if w.channel.BufferedAmount() > dataChannelMaxBufferedAmount {
+		return fmt.Errorf("frame %d: %w", frame.FrameNr, ErrDataChannelCongested)
+	}
+	frameLen := uint32(len(frame.Data))
+	for offset := uint32(0); offset < frameLen; offset += w.fragmentSize {
+		fragmentSize := w.fragmentSize
+		if frameLen-offset < fragmentSize {
+			fragmentSize = frameLen - offset
+		}
+		b, err := NewFramePacket(frame.FrameNr, frameLen, fragmentSize, offset, frame.Data).Marshal(FramePacketVersion)
+		if err != nil {
+			return err
+		}
+		if err := w.channel.Send(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
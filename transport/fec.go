@@ -0,0 +1,315 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+const (
+	// FECPacketType is the first byte of an XOR parity packet, it takes the place of the
+	// version byte of a compact FramePacket
+	FECPacketType uint8 = 2
+	// FECPacketHeaderSize is the size of the parity header, equal to FramePacketHeaderSize so
+	// parity packets fit in the same mtu as the fragments they protect
+	FECPacketHeaderSize = 1 + 3*4 + 2*2
+
+	// Number of frames the FECDecoder keeps fragments of
+	fecMaxFrames = 16
+)
+
+// FECPacket is the XOR of Count consecutive fragments of a frame, starting at FirstOffset.
+// Every fragment except the last one of the frame is FragmentSize bytes long, shorter
+// fragments are padded with zeros.
+type FECPacket struct {
+	FrameNr      uint32
+	FrameLen     uint32
+	FirstOffset  uint32
+	Count        uint16
+	FragmentSize uint16
+	Parity       []byte
+}
+
+func (f *FECPacket) Marshal() []byte {
+	buf := make([]byte, FECPacketHeaderSize+len(f.Parity))
+	buf[0] = FECPacketType
+	binary.LittleEndian.PutUint32(buf[1:], f.FrameNr)
+	binary.LittleEndian.PutUint32(buf[5:], f.FrameLen)
+	binary.LittleEndian.PutUint32(buf[9:], f.FirstOffset)
+	binary.LittleEndian.PutUint16(buf[13:], f.Count)
+	binary.LittleEndian.PutUint16(buf[15:], f.FragmentSize)
+	copy(buf[FECPacketHeaderSize:], f.Parity)
+	return buf
+}
+
+// IsFECPacket returns whether the payload is a parity packet instead of a fragment
+func IsFECPacket(payload []byte) bool {
+	return len(payload) > FECPacketHeaderSize && payload[0] == FECPacketType &&
+		int(binary.LittleEndian.Uint16(payload[15:])) == len(payload)-FECPacketHeaderSize
+}
+
+// ParseFECPacket parses a parity packet, Parity references payload
+func ParseFECPacket(payload []byte) (*FECPacket, error) {
+	if !IsFECPacket(payload) {
+		return nil, fmt.Errorf("%w: not a parity packet", ErrMalformedFramePacket)
+	}
+	f := &FECPacket{
+		FrameNr:      binary.LittleEndian.Uint32(payload[1:]),
+		FrameLen:     binary.LittleEndian.Uint32(payload[5:]),
+		FirstOffset:  binary.LittleEndian.Uint32(payload[9:]),
+		Count:        binary.LittleEndian.Uint16(payload[13:]),
+		FragmentSize: binary.LittleEndian.Uint16(payload[15:]),
+		Parity:       payload[FECPacketHeaderSize:],
+	}
+	if f.Count == 0 || uint64(f.FirstOffset)+uint64(f.Count-1)*uint64(f.FragmentSize) >= uint64(f.FrameLen) {
+		return nil, fmt.Errorf("%w: parity group %d+%dx%d exceeds frame length %d", ErrMalformedFramePacket, f.FirstOffset, f.Count, f.FragmentSize, f.FrameLen)
+	}
+	return f, nil
+}
+
+// FECGroupSize returns the number of fragments protected by one parity packet for a
+// protection ratio (parity packets per fragment), 0 means no protection
+func FECGroupSize(ratio float64) int {
+	if ratio <= 0 {
+		return 0
+	}
+	if ratio >= 1 {
+		return 1
+	}
+	return int(math.Min(math.Ceil(1/ratio), math.MaxUint16))
+}
+
+// FECOverhead returns the largest effective protection ratio, the share of the bitrate that
+// goes to parity packets is at most FECOverhead / (1 + FECOverhead)
+func FECOverhead(ratios []float64) float64 {
+	overhead := 0.0
+	for _, ratio := range ratios {
+		if k := FECGroupSize(ratio); k > 0 && 1/float64(k) > overhead {
+			overhead = 1 / float64(k)
+		}
+	}
+	return overhead
+}
+
+// NewFECPackets creates the parity packets of a frame that is cut in fragments of fragmentSize.
+// Fragments belong to the layer they start in, the protection ratio of a layer is ratios[layer ID].
+// Frames without layers are protected with ratios[0].
+func NewFECPackets(frameNr uint32, frame []byte, fragmentSize uint32, layers []pointcloud.Layer, ratios []float64) []*FECPacket {
+	if len(ratios) == 0 || fragmentSize == 0 || fragmentSize > math.MaxUint16 {
+		return nil
+	}
+	if len(layers) == 0 {
+		layers = []pointcloud.Layer{{ID: 0, Offset: 0, Len: uint32(len(frame))}}
+	}
+	frameLen := uint32(len(frame))
+	packets := make([]*FECPacket, 0)
+	var group *FECPacket
+	groupSize := 0
+	groupLayer := -1
+	for offset := uint32(0); offset < frameLen; offset += fragmentSize {
+		layer := layerAt(layers, offset)
+		if group != nil && (layer != groupLayer || int(group.Count) == groupSize) {
+			packets = append(packets, group)
+			group = nil
+		}
+		if group == nil {
+			groupLayer = layer
+			groupSize = 0
+			if layer >= 0 && layer < len(ratios) {
+				groupSize = FECGroupSize(ratios[layer])
+			}
+			if groupSize == 0 {
+				continue
+			}
+			group = &FECPacket{
+				FrameNr:      frameNr,
+				FrameLen:     frameLen,
+				FirstOffset:  offset,
+				FragmentSize: uint16(fragmentSize),
+				Parity:       make([]byte, fragmentSize),
+			}
+		}
+		end := offset + fragmentSize
+		if end > frameLen {
+			end = frameLen
+		}
+		xorInto(group.Parity, frame[offset:end])
+		group.Count++
+	}
+	if group != nil {
+		packets = append(packets, group)
+	}
+	return packets
+}
+
+// layerAt returns the ID of the layer containing offset, -1 if there is none
+func layerAt(layers []pointcloud.Layer, offset uint32) int {
+	for _, l := range layers {
+		if offset >= l.Offset && offset < l.Offset+l.Len {
+			return int(l.ID)
+		}
+	}
+	return -1
+}
+
+func xorInto(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
+
+type fecFrame struct {
+	frameLen  uint32
+	fragments map[uint32][]byte
+	parity    []*FECPacket
+}
+
+// FECDecoder keeps the fragments of recent frames and recovers a lost fragment as soon as
+// all other fragments of its parity group have been received
+type FECDecoder struct {
+	frames map[uint32]*fecFrame
+	order  []uint32
+}
+
+func NewFECDecoder() *FECDecoder {
+	return &FECDecoder{frames: make(map[uint32]*fecFrame)}
+}
+
+func (d *FECDecoder) frame(frameNr, frameLen uint32) *fecFrame {
+	f, ok := d.frames[frameNr]
+	if !ok {
+		f = &fecFrame{frameLen: frameLen, fragments: make(map[uint32][]byte)}
+		d.frames[frameNr] = f
+		d.order = append(d.order, frameNr)
+		if len(d.order) > fecMaxFrames {
+			delete(d.frames, d.order[0])
+			d.order = d.order[1:]
+		}
+	}
+	return f
+}
+
+// AddFragment stores a received fragment and returns the fragments it allowed to recover.
+// Fragments that are longer than the fragments of a parity group they belong to are ignored.
+func (d *FECDecoder) AddFragment(p *FramePacket) []*FramePacket {
+	f := d.frame(p.FrameNr, p.FrameLen)
+	if _, ok := f.fragments[p.SeqOffset]; ok || p.FrameLen != f.frameLen {
+		return nil
+	}
+	for _, parity := range f.parity {
+		if index, ok := parity.index(p.SeqOffset); ok && len(p.Data) != parity.fragmentLen(index) {
+			return nil
+		}
+	}
+	f.fragments[p.SeqOffset] = append([]byte(nil), p.Data...)
+	return d.recover(p.FrameNr, f)
+}
+
+// AddParity stores a parity packet and returns the fragments it allowed to recover. Parity packets
+// that do not match the frame length of the fragments are ignored.
+func (d *FECDecoder) AddParity(parity *FECPacket) []*FramePacket {
+	f := d.frame(parity.FrameNr, parity.FrameLen)
+	if parity.FrameLen != f.frameLen || len(parity.Parity) != int(parity.FragmentSize) || parity.Count == 0 ||
+		uint64(parity.FirstOffset)+uint64(parity.Count-1)*uint64(parity.FragmentSize) >= uint64(parity.FrameLen) {
+		return nil
+	}
+	f.parity = append(f.parity, &FECPacket{
+		FrameNr:      parity.FrameNr,
+		FrameLen:     parity.FrameLen,
+		FirstOffset:  parity.FirstOffset,
+		Count:        parity.Count,
+		FragmentSize: parity.FragmentSize,
+		Parity:       append([]byte(nil), parity.Parity...),
+	})
+	return d.recover(parity.FrameNr, f)
+}
+
+// Forget drops the state of a frame, e.g. once it is complete
+func (d *FECDecoder) Forget(frameNr uint32) {
+	if _, ok := d.frames[frameNr]; !ok {
+		return
+	}
+	delete(d.frames, frameNr)
+	for i, nr := range d.order {
+		if nr == frameNr {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// index returns the position of the fragment at offset in the parity group
+func (f *FECPacket) index(offset uint32) (int, bool) {
+	if f.FragmentSize == 0 || offset < f.FirstOffset || (offset-f.FirstOffset)%uint32(f.FragmentSize) != 0 {
+		return 0, false
+	}
+	i := (offset - f.FirstOffset) / uint32(f.FragmentSize)
+	return int(i), i < uint32(f.Count)
+}
+
+// fragmentLen returns the length of fragment i of the parity group, only the last fragment of the
+// frame is shorter than FragmentSize
+func (f *FECPacket) fragmentLen(i int) int {
+	offset := f.FirstOffset + uint32(i)*uint32(f.FragmentSize)
+	if offset+uint32(f.FragmentSize) > f.FrameLen {
+		return int(f.FrameLen - offset)
+	}
+	return int(f.FragmentSize)
+}
+
+func (d *FECDecoder) recover(frameNr uint32, f *fecFrame) []*FramePacket {
+	var recovered []*FramePacket
+	remaining := f.parity[:0]
+	for _, parity := range f.parity {
+		missing := -1
+		missingCount := 0
+		mismatched := false
+		for i := 0; i < int(parity.Count); i++ {
+			fragment, ok := f.fragments[parity.FirstOffset+uint32(i)*uint32(parity.FragmentSize)]
+			if !ok {
+				missing = i
+				missingCount++
+			} else if len(fragment) != parity.fragmentLen(i) {
+				mismatched = true
+			}
+		}
+		// The fragments were not cut like the parity group, it cannot recover anything
+		if mismatched {
+			continue
+		}
+		if missingCount > 1 {
+			remaining = append(remaining, parity)
+			continue
+		}
+		if missingCount == 0 {
+			continue
+		}
+		data := append([]byte(nil), parity.Parity...)
+		for i := 0; i < int(parity.Count); i++ {
+			if i != missing {
+				xorInto(data, f.fragments[parity.FirstOffset+uint32(i)*uint32(parity.FragmentSize)])
+			}
+		}
+		offset := parity.FirstOffset + uint32(missing)*uint32(parity.FragmentSize)
+		length := uint32(parity.FragmentSize)
+		if offset+length > f.frameLen {
+			length = f.frameLen - offset
+		}
+		f.fragments[offset] = data[:length]
+		recovered = append(recovered, &FramePacket{
+			FrameNr:   frameNr,
+			FrameLen:  f.frameLen,
+			SeqOffset: offset,
+			SeqLen:    length,
+			Data:      data[:length],
+		})
+	}
+	f.parity = remaining
+	// A recovered fragment can complete another parity group
+	if len(recovered) > 0 && len(f.parity) > 0 {
+		recovered = append(recovered, d.recover(frameNr, f)...)
+	}
+	return recovered
+}
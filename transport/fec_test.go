@@ -0,0 +1,218 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+// testFragments cuts data in fragments of size bytes
+func testFragments(frameNr uint32, data []byte, size uint32) []*FramePacket {
+	var fragments []*FramePacket
+	for offset := uint32(0); offset < uint32(len(data)); offset += size {
+		end := offset + size
+		if end > uint32(len(data)) {
+			end = uint32(len(data))
+		}
+		fragments = append(fragments, NewFramePacket(frameNr, uint32(len(data)), end-offset, offset, data))
+	}
+	return fragments
+}
+
+func TestNewFECPackets(t *testing.T) {
+	tests := []struct {
+		name         string
+		frameLen     uint32
+		fragmentSize uint32
+		layers       []pointcloud.Layer
+		ratios       []float64
+		// FirstOffset and Count of every parity packet
+		want [][2]uint32
+	}{
+		{"no ratios", 100, 20, nil, nil, nil},
+		{"zero fragment size", 100, 0, nil, []float64{1}, nil},
+		{"fragment size above uint16", 100, 1 << 16, nil, []float64{1}, nil},
+		{"every fragment", 60, 20, nil, []float64{1}, [][2]uint32{{0, 1}, {20, 1}, {40, 1}}},
+		{"groups of two", 100, 20, nil, []float64{0.5}, [][2]uint32{{0, 2}, {40, 2}, {80, 1}}},
+		{"short last fragment", 50, 20, nil, []float64{0.2}, [][2]uint32{{0, 3}}},
+		{"empty frame", 0, 20, nil, []float64{1}, nil},
+		{
+			name:         "per layer",
+			frameLen:     100,
+			fragmentSize: 20,
+			layers:       []pointcloud.Layer{{ID: 0, Offset: 0, Len: 40}, {ID: 1, Offset: 40, Len: 60}},
+			ratios:       []float64{0.5, 0},
+			want:         [][2]uint32{{0, 2}},
+		},
+		{
+			name:         "layer without ratio",
+			frameLen:     100,
+			fragmentSize: 20,
+			layers:       []pointcloud.Layer{{ID: 0, Offset: 0, Len: 40}, {ID: 3, Offset: 40, Len: 60}},
+			ratios:       []float64{0.5},
+			want:         [][2]uint32{{0, 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testFrameData(tt.frameLen)
+			packets := NewFECPackets(7, data, tt.fragmentSize, tt.layers, tt.ratios)
+			if len(packets) != len(tt.want) {
+				t.Fatalf("%d parity packets, want %d", len(packets), len(tt.want))
+			}
+			for i, p := range packets {
+				if p.FirstOffset != tt.want[i][0] || uint32(p.Count) != tt.want[i][1] {
+					t.Errorf("packet %d covers %d+%d, want %d+%d", i, p.FirstOffset, p.Count, tt.want[i][0], tt.want[i][1])
+				}
+				if p.FrameNr != 7 || p.FrameLen != tt.frameLen || len(p.Parity) != int(tt.fragmentSize) {
+					t.Errorf("packet %d = %+v", i, p)
+				}
+				parsed, err := ParseFECPacket(p.Marshal())
+				if err != nil {
+					t.Fatalf("packet %d: %v", i, err)
+				}
+				if parsed.FirstOffset != p.FirstOffset || parsed.Count != p.Count || !bytes.Equal(parsed.Parity, p.Parity) {
+					t.Errorf("packet %d parsed as %+v", i, parsed)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFECPacket(t *testing.T) {
+	valid := (&FECPacket{FrameNr: 1, FrameLen: 40, FirstOffset: 0, Count: 2, FragmentSize: 20, Parity: make([]byte, 20)}).Marshal()
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"empty", nil},
+		{"header only", valid[:FECPacketHeaderSize]},
+		{"truncated parity", valid[:len(valid)-1]},
+		{"fragment", append([]byte{FramePacketVersion}, valid[1:]...)},
+		{"zero count", (&FECPacket{FrameLen: 40, Count: 0, FragmentSize: 20, Parity: make([]byte, 20)}).Marshal()},
+		{"group beyond frame", (&FECPacket{FrameLen: 40, Count: 3, FragmentSize: 20, Parity: make([]byte, 20)}).Marshal()},
+		{"offset beyond frame", (&FECPacket{FrameLen: 40, FirstOffset: 40, Count: 1, FragmentSize: 20, Parity: make([]byte, 20)}).Marshal()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseFECPacket(tt.payload); !errors.Is(err, ErrMalformedFramePacket) {
+				t.Errorf("err = %v, want %v", err, ErrMalformedFramePacket)
+			}
+		})
+	}
+}
+
+func TestFECDecoder(t *testing.T) {
+	data := testFrameData(50)
+	fragments := testFragments(1, data, 20)
+	parity := NewFECPackets(1, data, 20, nil, []float64{0.2})[0]
+
+	tests := []struct {
+		name string
+		// Indexes of the fragments that arrive, in order
+		received    []int
+		parityFirst bool
+		// Index of the fragment that is recovered, -1 for none
+		want int
+	}{
+		{"first lost", []int{1, 2}, false, 0},
+		{"middle lost", []int{0, 2}, false, 1},
+		{"short last lost", []int{0, 1}, false, 2},
+		{"parity first", []int{2, 0}, true, 1},
+		{"two lost", []int{0}, false, -1},
+		{"nothing lost", []int{0, 1, 2}, false, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewFECDecoder()
+			var recovered []*FramePacket
+			if tt.parityFirst {
+				recovered = append(recovered, d.AddParity(parity)...)
+			}
+			for _, i := range tt.received {
+				recovered = append(recovered, d.AddFragment(fragments[i])...)
+			}
+			if !tt.parityFirst {
+				recovered = append(recovered, d.AddParity(parity)...)
+			}
+			if tt.want < 0 {
+				if len(recovered) != 0 {
+					t.Fatalf("recovered %d fragments, want none", len(recovered))
+				}
+				return
+			}
+			if len(recovered) != 1 {
+				t.Fatalf("recovered %d fragments, want 1", len(recovered))
+			}
+			want := fragments[tt.want]
+			got := recovered[0]
+			if got.SeqOffset != want.SeqOffset || got.SeqLen != want.SeqLen || !bytes.Equal(got.Data, want.Data) {
+				t.Errorf("recovered %d+%d %v, want %d+%d %v", got.SeqOffset, got.SeqLen, got.Data, want.SeqOffset, want.SeqLen, want.Data)
+			}
+		})
+	}
+}
+
+func TestFECDecoderMalformed(t *testing.T) {
+	data := testFrameData(60)
+	parity := &FECPacket{FrameNr: 1, FrameLen: 60, FirstOffset: 0, Count: 3, FragmentSize: 20, Parity: make([]byte, 20)}
+
+	tests := []struct {
+		name        string
+		fragments   []*FramePacket
+		parity      *FECPacket
+		parityFirst bool
+	}{
+		{
+			name:      "oversized fragment before parity",
+			fragments: []*FramePacket{NewFramePacket(1, 60, 30, 0, data), NewFramePacket(1, 60, 20, 20, data)},
+			parity:    parity,
+		},
+		{
+			name:        "oversized fragment after parity",
+			fragments:   []*FramePacket{NewFramePacket(1, 60, 30, 0, data), NewFramePacket(1, 60, 20, 20, data)},
+			parity:      parity,
+			parityFirst: true,
+		},
+		{
+			name:      "short fragment",
+			fragments: []*FramePacket{NewFramePacket(1, 60, 10, 0, data), NewFramePacket(1, 60, 20, 20, data)},
+			parity:    parity,
+		},
+		{
+			name:      "parity of another frame length",
+			fragments: []*FramePacket{NewFramePacket(1, 60, 20, 0, data), NewFramePacket(1, 60, 20, 20, data)},
+			parity:    &FECPacket{FrameNr: 1, FrameLen: 100, FirstOffset: 0, Count: 3, FragmentSize: 20, Parity: make([]byte, 20)},
+		},
+		{
+			name:      "parity shorter than its fragments",
+			fragments: []*FramePacket{NewFramePacket(1, 60, 20, 0, data), NewFramePacket(1, 60, 20, 20, data)},
+			parity:    &FECPacket{FrameNr: 1, FrameLen: 60, FirstOffset: 0, Count: 3, FragmentSize: 20, Parity: make([]byte, 10)},
+		},
+		{
+			name:      "parity group beyond frame",
+			fragments: []*FramePacket{NewFramePacket(1, 60, 20, 0, data), NewFramePacket(1, 60, 20, 20, data)},
+			parity:    &FECPacket{FrameNr: 1, FrameLen: 60, FirstOffset: 20, Count: 3, FragmentSize: 20, Parity: make([]byte, 20)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewFECDecoder()
+			var recovered []*FramePacket
+			if tt.parityFirst {
+				recovered = append(recovered, d.AddParity(tt.parity)...)
+			}
+			for _, f := range tt.fragments {
+				recovered = append(recovered, d.AddFragment(f)...)
+			}
+			if !tt.parityFirst {
+				recovered = append(recovered, d.AddParity(tt.parity)...)
+			}
+			if len(recovered) != 0 {
+				t.Errorf("recovered %+v from malformed input", recovered)
+			}
+		})
+	}
+}
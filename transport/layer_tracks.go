@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/layered"
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+// SplitLayers splits a multi-layer frame over streams RTP streams, layer i is sent on stream i
+// and layers beyond the last stream share the last stream. Stream 0 always starts with the main
+// header, also when the frame has no layer 0, the other streams start with the side header of their
+// first layer. Every part keeps the frame number and capture time, its Layers are relative to the
+// part. Frames without layers are sent on stream 0 and streams without layers get a nil part.
+func SplitLayers(frame *pointcloud.Frame, streams int) []*pointcloud.Frame {
+	parts := make([]*pointcloud.Frame, streams)
+	if streams == 0 {
+		return parts
+	}
+	mainSize := uint32(binary.Size(layered.MultiLayerMainHeader{}))
+	if len(frame.Layers) == 0 || streams == 1 || len(frame.Data) < int(mainSize) {
+		parts[0] = frame
+		return parts
+	}
+	layers := append([]pointcloud.Layer(nil), frame.Layers...)
+	sort.Slice(layers, func(i, j int) bool { return layers[i].Offset < layers[j].Offset })
+	if layers[0].Offset != 0 || layers[0].Len < mainSize {
+		// The main header is not part of the first layer, the frame cannot be split
+		parts[0] = frame
+		return parts
+	}
+	parts[0] = &pointcloud.Frame{ClientID: frame.ClientID, FrameNr: frame.FrameNr, CaptureTime: frame.CaptureTime}
+	parts[0].Data = append(parts[0].Data, frame.Data[:mainSize]...)
+	for _, l := range layers {
+		stream := int(l.ID)
+		if stream >= streams {
+			stream = streams - 1
+		}
+		part := parts[stream]
+		if part == nil {
+			part = &pointcloud.Frame{ClientID: frame.ClientID, FrameNr: frame.FrameNr, CaptureTime: frame.CaptureTime}
+			parts[stream] = part
+		}
+		data := frame.Data[l.Offset : l.Offset+l.Len]
+		if l.Offset == 0 {
+			data = data[mainSize:]
+		}
+		layer := pointcloud.Layer{ID: l.ID, Offset: uint32(len(part.Data)), Len: uint32(len(data))}
+		if stream == 0 && len(part.Layers) == 0 {
+			// Like in the complete frame, the main header is part of the first layer
+			layer.Offset = 0
+			layer.Len += mainSize
+		}
+		part.Layers = append(part.Layers, layer)
+		part.Data = append(part.Data, data...)
+	}
+	for _, part := range parts {
+		if part != nil {
+			part.FrameLen = uint32(len(part.Data))
+		}
+	}
+	return parts
+}
+
+// LayeredFrameWriter sends every layer of a frame on its own track so a lost packet of an
+// enhancement layer only delays that layer, the base layer is written first
+type LayeredFrameWriter struct {
+	tracks []*TrackLocalCloudRTP
+}
+
+func NewLayeredFrameWriter(tracks []*TrackLocalCloudRTP) *LayeredFrameWriter {
+	return &LayeredFrameWriter{tracks: tracks}
+}
+
+func (w *LayeredFrameWriter) WriteFrame(frame *pointcloud.Frame) error {
+	var errs []error
+	for i, part := range SplitLayers(frame, len(w.tracks)) {
+		if part == nil {
+			continue
+		}
+		if err := w.tracks[i].WriteFrame(part); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
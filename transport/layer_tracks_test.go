@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MatthiasDeFre/webrtc-pc-server/layered"
+	"github.com/MatthiasDeFre/webrtc-pc-server/pointcloud"
+)
+
+const testLayerLen = 10
+
+var testMainSize = binary.Size(layered.MultiLayerMainHeader{})
+
+// testLayeredFrame builds a frame with a main header of 0xff bytes followed by testLayerLen
+// bytes of every layer ID, the first layer includes the main header
+func testLayeredFrame(ids ...uint32) *pointcloud.Frame {
+	frame := &pointcloud.Frame{FrameNr: 9, CaptureTime: time.Unix(1, 0)}
+	frame.Data = bytes.Repeat([]byte{0xff}, testMainSize)
+	for i, id := range ids {
+		layer := pointcloud.Layer{ID: id, Offset: uint32(len(frame.Data)), Len: testLayerLen}
+		if i == 0 {
+			layer.Offset = 0
+			layer.Len += uint32(testMainSize)
+		}
+		frame.Layers = append(frame.Layers, layer)
+		frame.Data = append(frame.Data, bytes.Repeat([]byte{byte(id)}, testLayerLen)...)
+	}
+	frame.FrameLen = uint32(len(frame.Data))
+	return frame
+}
+
+func TestSplitLayers(t *testing.T) {
+	tiled := &pointcloud.Frame{FrameNr: 9, Data: make([]byte, 64)}
+	binary.LittleEndian.PutUint32(tiled.Data, layered.TiledFrameMagic)
+	tiled.FrameLen = uint32(len(tiled.Data))
+	headerOutsideLayers := testLayeredFrame(0, 1)
+	headerOutsideLayers.Layers[0].Offset = uint32(testMainSize)
+	headerOutsideLayers.Layers[0].Len = testLayerLen
+
+	tests := []struct {
+		name    string
+		frame   *pointcloud.Frame
+		streams int
+		// Layer IDs of the part of every stream, nil for streams without a part. Ignored when
+		// the frame is expected on stream 0 as a whole.
+		want  [][]uint32
+		whole bool
+	}{
+		{name: "layer per stream", frame: testLayeredFrame(0, 1, 2), streams: 3, want: [][]uint32{{0}, {1}, {2}}},
+		{name: "more layers than streams", frame: testLayeredFrame(0, 1, 2, 3), streams: 2, want: [][]uint32{{0}, {1, 2, 3}}},
+		{name: "more streams than layers", frame: testLayeredFrame(0, 1), streams: 3, want: [][]uint32{{0}, {1}, nil}},
+		{name: "no layer 0", frame: testLayeredFrame(1, 2), streams: 3, want: [][]uint32{{}, {1}, {2}}},
+		{name: "enhancement layers only on one stream", frame: testLayeredFrame(2), streams: 2, want: [][]uint32{{}, {2}}},
+		{name: "single stream", frame: testLayeredFrame(0, 1), streams: 1, whole: true},
+		{name: "not layered", frame: &pointcloud.Frame{FrameNr: 9, FrameLen: 64, Data: make([]byte, 64)}, streams: 3, whole: true},
+		{name: "tiled", frame: tiled, streams: 3, whole: true},
+		{name: "shorter than the main header", frame: &pointcloud.Frame{Data: make([]byte, 4), Layers: []pointcloud.Layer{{Len: 4}}}, streams: 2, whole: true},
+		{name: "main header outside the first layer", frame: headerOutsideLayers, streams: 2, whole: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := SplitLayers(tt.frame, tt.streams)
+			if len(parts) != tt.streams {
+				t.Fatalf("%d parts, want %d", len(parts), tt.streams)
+			}
+			if tt.whole {
+				if parts[0] != tt.frame {
+					t.Errorf("stream 0 = %+v, want the whole frame", parts[0])
+				}
+				for i, part := range parts[1:] {
+					if part != nil {
+						t.Errorf("stream %d = %+v, want nil", i+1, part)
+					}
+				}
+				return
+			}
+			for i, part := range parts {
+				if tt.want[i] == nil {
+					if part != nil {
+						t.Errorf("stream %d = %+v, want nil", i, part)
+					}
+					continue
+				}
+				if part == nil {
+					t.Fatalf("stream %d has no part, want layers %v", i, tt.want[i])
+				}
+				if part.FrameNr != tt.frame.FrameNr || !part.CaptureTime.Equal(tt.frame.CaptureTime) || part.FrameLen != uint32(len(part.Data)) {
+					t.Errorf("stream %d = %d %v %d, want frame %d %v of %d bytes", i, part.FrameNr, part.CaptureTime, part.FrameLen,
+						tt.frame.FrameNr, tt.frame.CaptureTime, len(part.Data))
+				}
+				// Stream 0 starts with the main header, followed by the data of its layers
+				var want []byte
+				if i == 0 {
+					want = bytes.Repeat([]byte{0xff}, testMainSize)
+				}
+				ids := make([]uint32, 0)
+				for _, layer := range part.Layers {
+					ids = append(ids, layer.ID)
+					want = append(want, bytes.Repeat([]byte{byte(layer.ID)}, testLayerLen)...)
+				}
+				if !reflect.DeepEqual(ids, tt.want[i]) {
+					t.Errorf("stream %d has layers %v, want %v", i, ids, tt.want[i])
+				}
+				if !bytes.Equal(part.Data, want) {
+					t.Errorf("stream %d data = %v, want %v", i, part.Data, want)
+				}
+				// The layers of a part cover its data, the main header belongs to the first layer
+				offset := uint32(0)
+				if i == 0 && len(part.Layers) == 0 {
+					offset = uint32(testMainSize)
+				}
+				for _, layer := range part.Layers {
+					if layer.Offset != offset {
+						t.Errorf("stream %d layer %d starts at %d, want %d", i, layer.ID, layer.Offset, offset)
+					}
+					offset += layer.Len
+				}
+				if offset != uint32(len(part.Data)) {
+					t.Errorf("stream %d layers cover %d of %d bytes", i, offset, len(part.Data))
+				}
+			}
+		})
+	}
+}
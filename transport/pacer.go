@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+var errPacerClosed = errors.New("pacer: closed")
+
+type pacerStreamKeyType struct{}
+
+// pacerStreamKey is the attribute with the SSRC of the registered stream whose writer sends a
+// packet with an SSRC of its own, such as a retransmission on an RTX SSRC
+var pacerStreamKey = pacerStreamKeyType{}
+
+// Pacer is a leaky bucket that spreads the packets of a frame over time instead of sending them
+// back-to-back. It drains at the target bitrate of the bandwidth estimator times the multiplier
+// and lets at most burst bytes through without waiting. It replaces the pacer of the GCC bandwidth
+// estimator (gcc.SendSideBWEPacer), so all streams of a connection share it. A write blocks until
+// the packet is sent, so the writer of a frame gets back-pressure instead of a queue.
+type Pacer struct {
+	mux        sync.Mutex
+	multiplier float64
+	burst      float64
+	// Drain rate in bytes per second, 0 disables pacing
+	rate float64
+	// Bytes that can be sent without waiting, negative while packets are ahead of the rate
+	budget     float64
+	lastRefill time.Time
+
+	writersMux sync.RWMutex
+	writers    map[uint32]interceptor.RTPWriter
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewPacer creates a pacer that drains at initialBitrate * multiplier bits per second until the
+// bandwidth estimator sets a target, a multiplier of 0 disables pacing
+func NewPacer(initialBitrate int, multiplier float64, burst int) *Pacer {
+	p := &Pacer{
+		multiplier: multiplier,
+		burst:      float64(burst),
+		budget:     float64(burst),
+		lastRefill: time.Now(),
+		writers:    make(map[uint32]interceptor.RTPWriter),
+		closed:     make(chan struct{}),
+	}
+	p.SetTargetBitrate(initialBitrate)
+	return p
+}
+
+// AddStream registers the writer of an outgoing stream
+func (p *Pacer) AddStream(ssrc uint32, writer interceptor.RTPWriter) {
+	p.writersMux.Lock()
+	defer p.writersMux.Unlock()
+	p.writers[ssrc] = writer
+}
+
+// SetTargetBitrate is called by the bandwidth estimator with every new estimate
+func (p *Pacer) SetTargetBitrate(bitrate int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.rate = float64(bitrate) * p.multiplier / 8
+}
+
+// Write sends a packet once the rate allows it. Packets with an unregistered SSRC are sent with
+// the writer of the stream in their pacerStreamKey attribute.
+func (p *Pacer) Write(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	p.writersMux.RLock()
+	writer, ok := p.writers[header.SSRC]
+	if !ok {
+		if ssrc, hasStream := attributes.Get(pacerStreamKey).(uint32); hasStream {
+			writer, ok = p.writers[ssrc]
+		}
+	}
+	p.writersMux.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("pacer: no stream for ssrc %d", header.SSRC)
+	}
+	if delay := p.reserve(header.MarshalSize() + len(payload)); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-p.closed:
+			timer.Stop()
+			return 0, errPacerClosed
+		}
+	}
+	return writer.Write(header, payload, attributes)
+}
+
+// reserve takes size bytes from the budget and returns how long the caller has to wait before
+// sending them. The lock is not held while waiting so the other streams can reserve their slots.
+func (p *Pacer) reserve(size int) time.Duration {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	now := time.Now()
+	if p.rate <= 0 {
+		p.lastRefill = now
+		return 0
+	}
+	p.budget += now.Sub(p.lastRefill).Seconds() * p.rate
+	if p.budget > p.burst {
+		p.budget = p.burst
+	}
+	p.lastRefill = now
+	p.budget -= float64(size)
+	if p.budget >= 0 {
+		return 0
+	}
+	return time.Duration(-p.budget / p.rate * float64(time.Second))
+}
+
+// Close releases the writers that are waiting
+func (p *Pacer) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
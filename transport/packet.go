@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MaxFragmentSize is the largest amount of frame data a single FramePacket can carry
+const MaxFragmentSize = 1180
+
+const (
+	// DefaultMTU is the default maximum size of an RTP packet, header and extensions included
+	DefaultMTU = 1220
+	// RTPPacketOverhead is the RTP header plus room for the transport-wide CC header extension
+	RTPPacketOverhead = 12 + 8
+)
+
+const (
+	// FramePacketVersionLegacy is the original format without a version byte where every
+	// fragment carries MaxFragmentSize bytes of data, padded with zeros
+	FramePacketVersionLegacy uint8 = 0
+	// FramePacketVersion is the compact format, a version byte followed by the header and
+	// only the SeqLen bytes of frame data
+	FramePacketVersion uint8 = 1
+
+	// FramePacketHeaderSize is the size of the compact header including the version byte
+	FramePacketHeaderSize = 1 + 4*4
+	// LegacyFramePacketSize is the size of every fragment in the legacy format
+	LegacyFramePacketSize = 4*4 + MaxFragmentSize
+)
+
+var ErrMalformedFramePacket = errors.New("malformed frame packet")
+
+// FramePacket is a single fragment of a frame, all fields are little endian on the wire
+type FramePacket struct {
+	FrameNr   uint32
+	FrameLen  uint32
+	SeqOffset uint32
+	SeqLen    uint32
+	Data      []byte
+}
+
+// NewFramePacket creates the fragment of data starting at seqOffset, Data references data
+func NewFramePacket(frameNr, frameLen, seqLen, seqOffset uint32, data []byte) *FramePacket {
+	return &FramePacket{
+		FrameNr:   frameNr,
+		FrameLen:  frameLen,
+		SeqOffset: seqOffset,
+		SeqLen:    seqLen,
+		Data:      data[seqOffset:(seqOffset + seqLen)],
+	}
+}
+
+// Marshal serialises the packet in the given wire format version
+func (p *FramePacket) Marshal(version uint8) ([]byte, error) {
+	var buf []byte
+	var header []byte
+	switch version {
+	case FramePacketVersionLegacy:
+		if len(p.Data) > MaxFragmentSize {
+			return nil, fmt.Errorf("%w: %d bytes of data exceed %d", ErrMalformedFramePacket, len(p.Data), MaxFragmentSize)
+		}
+		buf = make([]byte, LegacyFramePacketSize)
+		header = buf
+	case FramePacketVersion:
+		buf = make([]byte, FramePacketHeaderSize+len(p.Data))
+		buf[0] = version
+		header = buf[1:]
+	default:
+		return nil, fmt.Errorf("%w: unknown version %d", ErrMalformedFramePacket, version)
+	}
+	binary.LittleEndian.PutUint32(header[0:], p.FrameNr)
+	binary.LittleEndian.PutUint32(header[4:], p.FrameLen)
+	binary.LittleEndian.PutUint32(header[8:], p.SeqOffset)
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(p.Data)))
+	copy(header[16:], p.Data)
+	return buf, nil
+}
+
+// ParseFramePacket parses a fragment in either wire format. Compact packets are recognised by
+// their version byte and a SeqLen that matches the payload length, legacy packets by their size.
+// Data references payload.
+func ParseFramePacket(payload []byte) (*FramePacket, uint8, error) {
+	version := FramePacketVersionLegacy
+	header := payload
+	if len(payload) >= FramePacketHeaderSize && payload[0] == FramePacketVersion &&
+		int(binary.LittleEndian.Uint32(payload[13:])) == len(payload)-FramePacketHeaderSize {
+		version = FramePacketVersion
+		header = payload[1:]
+	} else if len(payload) != LegacyFramePacketSize {
+		return nil, 0, fmt.Errorf("%w: unexpected length %d", ErrMalformedFramePacket, len(payload))
+	}
+	p := &FramePacket{
+		FrameNr:   binary.LittleEndian.Uint32(header[0:]),
+		FrameLen:  binary.LittleEndian.Uint32(header[4:]),
+		SeqOffset: binary.LittleEndian.Uint32(header[8:]),
+		SeqLen:    binary.LittleEndian.Uint32(header[12:]),
+	}
+	if p.SeqLen > uint32(len(header)-16) || uint64(p.SeqOffset)+uint64(p.SeqLen) > uint64(p.FrameLen) {
+		return nil, 0, fmt.Errorf("%w: fragment %d+%d exceeds frame length %d", ErrMalformedFramePacket, p.SeqOffset, p.SeqLen, p.FrameLen)
+	}
+	p.Data = header[16 : 16+p.SeqLen]
+	return p, version, nil
+}
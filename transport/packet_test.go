@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestFramePacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  uint8
+		frameNr  uint32
+		frameLen uint32
+		start    uint32
+		end      uint32
+		// Length of the marshalled packet
+		wantLen int
+	}{
+		{"compact", FramePacketVersion, 7, 3000, 1180, 2360, FramePacketHeaderSize + 1180},
+		{"compact short last", FramePacketVersion, 7, 3000, 2360, 3000, FramePacketHeaderSize + 640},
+		{"compact empty", FramePacketVersion, 7, 3000, 3000, 3000, FramePacketHeaderSize},
+		{"compact with legacy size", FramePacketVersion, 7, 3000, 0, 1179, LegacyFramePacketSize},
+		{"legacy", FramePacketVersionLegacy, 7, 3000, 1180, 2360, LegacyFramePacketSize},
+		{"legacy short last", FramePacketVersionLegacy, 7, 3000, 2360, 3000, LegacyFramePacketSize},
+		{"legacy frame 1", FramePacketVersionLegacy, 1, 3000, 0, 100, LegacyFramePacketSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testFrameData(tt.frameLen)
+			packet := fragment{tt.frameNr, tt.frameLen, tt.start, tt.end}.packet(data)
+			b, err := packet.Marshal(tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) != tt.wantLen {
+				t.Errorf("marshalled %d bytes, want %d", len(b), tt.wantLen)
+			}
+			parsed, version, err := ParseFramePacket(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.version {
+				t.Errorf("version = %d, want %d", version, tt.version)
+			}
+			if parsed.FrameNr != packet.FrameNr || parsed.FrameLen != packet.FrameLen || parsed.SeqOffset != packet.SeqOffset ||
+				parsed.SeqLen != packet.SeqLen || !bytes.Equal(parsed.Data, packet.Data) {
+				t.Errorf("parsed %d %d %d+%d, want %d %d %d+%d", parsed.FrameNr, parsed.FrameLen, parsed.SeqOffset, parsed.SeqLen,
+					packet.FrameNr, packet.FrameLen, packet.SeqOffset, packet.SeqLen)
+			}
+		})
+	}
+}
+
+func TestFramePacketMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  *FramePacket
+		version uint8
+	}{
+		{"unknown version", &FramePacket{FrameLen: 10, SeqLen: 10, Data: make([]byte, 10)}, 3},
+		{"parity type", &FramePacket{FrameLen: 10, SeqLen: 10, Data: make([]byte, 10)}, FECPacketType},
+		{"legacy oversized", &FramePacket{FrameLen: 2000, SeqLen: 1181, Data: make([]byte, 1181)}, FramePacketVersionLegacy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.packet.Marshal(tt.version); !errors.Is(err, ErrMalformedFramePacket) {
+				t.Errorf("err = %v, want %v", err, ErrMalformedFramePacket)
+			}
+		})
+	}
+}
+
+func TestParseFramePacketErrors(t *testing.T) {
+	data := testFrameData(100)
+	compact, _ := fragment{1, 100, 0, 40}.packet(data).Marshal(FramePacketVersion)
+	legacy, _ := fragment{1, 100, 0, 40}.packet(data).Marshal(FramePacketVersionLegacy)
+
+	// withSeqLen overwrites SeqLen of a copy of a marshalled packet whose header starts at header
+	withSeqLen := func(b []byte, header int, seqLen uint32) []byte {
+		b = append([]byte(nil), b...)
+		binary.LittleEndian.PutUint32(b[header+12:], seqLen)
+		return b
+	}
+	withFrameLen := func(b []byte, header int, frameLen uint32) []byte {
+		b = append([]byte(nil), b...)
+		binary.LittleEndian.PutUint32(b[header+4:], frameLen)
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"empty", nil},
+		{"truncated compact header", compact[:FramePacketHeaderSize-1]},
+		{"truncated compact data", compact[:len(compact)-1]},
+		{"compact with trailing data", append(append([]byte(nil), compact...), 0)},
+		{"compact seq len beyond payload", withSeqLen(compact, 1, 41)},
+		{"compact fragment beyond frame", withFrameLen(compact, 1, 39)},
+		{"truncated legacy", legacy[:len(legacy)-1]},
+		{"legacy with trailing data", append(append([]byte(nil), legacy...), 0)},
+		{"legacy seq len beyond payload", withSeqLen(legacy, 0, MaxFragmentSize+1)},
+		{"legacy fragment beyond frame", withFrameLen(legacy, 0, 39)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p, _, err := ParseFramePacket(tt.payload); !errors.Is(err, ErrMalformedFramePacket) {
+				t.Errorf("parsed %+v, %v, want %v", p, err, ErrMalformedFramePacket)
+			}
+		})
+	}
+}
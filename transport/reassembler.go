@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// Number of frames that can be incomplete at the same time, the oldest one is dropped first
+	reassemblerMaxFrames = 64
+	// Number of finished frame numbers that are remembered to recognise late fragments
+	reassemblerFinishedFrames = 256
+)
+
+// ByteRange is the range [Start, End) of a frame
+type ByteRange struct {
+	Start uint32
+	End   uint32
+}
+
+// FrameStats describes how a frame was received, either once it is complete or when it is dropped
+type FrameStats struct {
+	FrameNr       uint32
+	FrameLen      uint32
+	ReceivedBytes uint32
+	Fragments     uint32
+	// Fragments that did not contain any new bytes
+	Duplicates uint32
+	// Ranges that were not received, empty for complete frames
+	Missing     []ByteRange
+	FirstPacket time.Time
+	// Time at which the frame was completed or dropped
+	Done     time.Time
+	Complete bool
+}
+
+// Completeness is the share of the frame that was received
+func (s FrameStats) Completeness() float64 {
+	if s.FrameLen == 0 {
+		return 1
+	}
+	return float64(s.ReceivedBytes) / float64(s.FrameLen)
+}
+
+// Latency is the time between the first fragment and completion or drop of the frame
+func (s FrameStats) Latency() time.Duration {
+	return s.Done.Sub(s.FirstPacket)
+}
+
+// ReassembledFrame is a complete frame
+type ReassembledFrame struct {
+	FrameNr  uint32
+	FrameLen uint32
+	Data     []byte
+	Stats    FrameStats
+}
+
+type pendingFrame struct {
+	data  []byte
+	stats FrameStats
+	// Received ranges, sorted and merged
+	received []ByteRange
+}
+
+// insert marks [start, end) as received and returns the number of bytes that were new
+func (f *pendingFrame) insert(start, end uint32) uint32 {
+	i := sort.Search(len(f.received), func(i int) bool { return f.received[i].End >= start })
+	merged := ByteRange{start, end}
+	added := end - start
+	j := i
+	for ; j < len(f.received) && f.received[j].Start <= end; j++ {
+		r := f.received[j]
+		overlapStart, overlapEnd := r.Start, r.End
+		if start > overlapStart {
+			overlapStart = start
+		}
+		if end < overlapEnd {
+			overlapEnd = end
+		}
+		if overlapEnd > overlapStart {
+			added -= overlapEnd - overlapStart
+		}
+		if r.Start < merged.Start {
+			merged.Start = r.Start
+		}
+		if r.End > merged.End {
+			merged.End = r.End
+		}
+	}
+	f.received = append(f.received[:i], append([]ByteRange{merged}, f.received[j:]...)...)
+	return added
+}
+
+func (f *pendingFrame) missing() []ByteRange {
+	var missing []ByteRange
+	offset := uint32(0)
+	for _, r := range f.received {
+		if r.Start > offset {
+			missing = append(missing, ByteRange{offset, r.Start})
+		}
+		offset = r.End
+	}
+	if offset < f.stats.FrameLen {
+		missing = append(missing, ByteRange{offset, f.stats.FrameLen})
+	}
+	return missing
+}
+
+// Reassembler places the fragments of frames at their offset, independent of the order in which
+// they arrive. Duplicated and overlapping fragments are only counted once and frames that are not
+// complete within the deadline are dropped. Frames larger than maxFrameSize are rejected and the
+// oldest frames are dropped when the incomplete frames would take more than maxBufferedBytes.
+// It is not safe for concurrent use.
+type Reassembler struct {
+	deadline         time.Duration
+	maxFrameSize     uint32
+	maxBufferedBytes uint64
+	// Bytes allocated for incomplete frames
+	buffered uint64
+	frames   map[uint32]*pendingFrame
+	// Frame numbers of incomplete frames, oldest first
+	order []uint32
+	// Frames that were dropped to make room for a new frame, returned by the next Expire
+	evicted       []FrameStats
+	finished      map[uint32]struct{}
+	finishedOrder []uint32
+}
+
+func NewReassembler(deadline time.Duration, maxFrameSize uint32, maxBufferedBytes uint32) *Reassembler {
+	return &Reassembler{
+		deadline:         deadline,
+		maxFrameSize:     maxFrameSize,
+		maxBufferedBytes: uint64(maxBufferedBytes),
+		frames:           make(map[uint32]*pendingFrame),
+		finished:         make(map[uint32]struct{}),
+	}
+}
+
+// Add stores a fragment received at now and returns the frame once it is complete. Fragments of
+// frames that were already completed or dropped are ignored.
+func (r *Reassembler) Add(p *FramePacket, now time.Time) (*ReassembledFrame, error) {
+	if _, ok := r.finished[p.FrameNr]; ok {
+		return nil, nil
+	}
+	if uint64(p.SeqOffset)+uint64(len(p.Data)) > uint64(p.FrameLen) {
+		return nil, fmt.Errorf("%w: fragment %d+%d exceeds frame length %d", ErrMalformedFramePacket, p.SeqOffset, len(p.Data), p.FrameLen)
+	}
+	f, ok := r.frames[p.FrameNr]
+	if !ok {
+		if p.FrameLen > r.maxFrameSize || uint64(p.FrameLen) > r.maxBufferedBytes {
+			return nil, fmt.Errorf("%w: frame %d length %d exceeds the maximum of %d", ErrMalformedFramePacket, p.FrameNr, p.FrameLen, r.maxFrameSize)
+		}
+		for r.buffered+uint64(p.FrameLen) > r.maxBufferedBytes {
+			r.evicted = append(r.evicted, r.drop(r.order[0], now))
+		}
+		r.buffered += uint64(p.FrameLen)
+		f = &pendingFrame{
+			data:  make([]byte, p.FrameLen),
+			stats: FrameStats{FrameNr: p.FrameNr, FrameLen: p.FrameLen, FirstPacket: now},
+		}
+		r.frames[p.FrameNr] = f
+		r.order = append(r.order, p.FrameNr)
+	}
+	if f.stats.FrameLen != p.FrameLen {
+		return nil, fmt.Errorf("%w: frame %d length changed from %d to %d", ErrMalformedFramePacket, p.FrameNr, f.stats.FrameLen, p.FrameLen)
+	}
+	f.stats.Fragments++
+	if len(p.Data) > 0 {
+		if added := f.insert(p.SeqOffset, p.SeqOffset+uint32(len(p.Data))); added > 0 {
+			copy(f.data[p.SeqOffset:], p.Data)
+			f.stats.ReceivedBytes += added
+		} else {
+			f.stats.Duplicates++
+		}
+	}
+	if f.stats.ReceivedBytes != f.stats.FrameLen {
+		return nil, nil
+	}
+	r.finish(p.FrameNr)
+	f.stats.Done = now
+	f.stats.Complete = true
+	return &ReassembledFrame{FrameNr: p.FrameNr, FrameLen: p.FrameLen, Data: f.data, Stats: f.stats}, nil
+}
+
+// Expire drops the frames whose first fragment arrived more than the deadline before now, and
+// the oldest frames when too many are incomplete. It returns the statistics of the dropped frames,
+// including the frames that Add dropped to stay within the buffer size.
+func (r *Reassembler) Expire(now time.Time) []FrameStats {
+	dropped := r.evicted
+	r.evicted = nil
+	for len(r.order) > 0 {
+		f := r.frames[r.order[0]]
+		if len(r.order) <= reassemblerMaxFrames && now.Sub(f.stats.FirstPacket) <= r.deadline {
+			break
+		}
+		dropped = append(dropped, r.drop(f.stats.FrameNr, now))
+	}
+	return dropped
+}
+
+// drop removes an incomplete frame and returns its statistics
+func (r *Reassembler) drop(frameNr uint32, now time.Time) FrameStats {
+	f := r.frames[frameNr]
+	r.finish(frameNr)
+	f.stats.Done = now
+	f.stats.Missing = f.missing()
+	return f.stats
+}
+
+// Missing returns the ranges of an incomplete frame that were not received yet
+func (r *Reassembler) Missing(frameNr uint32) []ByteRange {
+	if f, ok := r.frames[frameNr]; ok {
+		return f.missing()
+	}
+	return nil
+}
+
+func (r *Reassembler) finish(frameNr uint32) {
+	if f, ok := r.frames[frameNr]; ok {
+		r.buffered -= uint64(f.stats.FrameLen)
+	}
+	delete(r.frames, frameNr)
+	for i, nr := range r.order {
+		if nr == frameNr {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	r.finished[frameNr] = struct{}{}
+	r.finishedOrder = append(r.finishedOrder, frameNr)
+	if len(r.finishedOrder) > reassemblerFinishedFrames {
+		delete(r.finished, r.finishedOrder[0])
+		r.finishedOrder = r.finishedOrder[1:]
+	}
+}
@@ -0,0 +1,208 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fragment struct {
+	frameNr  uint32
+	frameLen uint32
+	start    uint32
+	end      uint32
+}
+
+func (f fragment) packet(data []byte) *FramePacket {
+	return &FramePacket{
+		FrameNr:   f.frameNr,
+		FrameLen:  f.frameLen,
+		SeqOffset: f.start,
+		SeqLen:    f.end - f.start,
+		Data:      data[f.start:f.end],
+	}
+}
+
+func testFrameData(n uint32) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func TestReassemblerAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		fragments []fragment
+		// Index of the fragment that completes the frame, -1 when it stays incomplete
+		completeAt   int
+		numFragments uint32
+		duplicates   uint32
+		missing      []ByteRange
+	}{
+		{
+			name:         "in order",
+			fragments:    []fragment{{1, 30, 0, 10}, {1, 30, 10, 20}, {1, 30, 20, 30}},
+			completeAt:   2,
+			numFragments: 3,
+		},
+		{
+			name:         "reordered",
+			fragments:    []fragment{{1, 30, 20, 30}, {1, 30, 0, 10}, {1, 30, 10, 20}},
+			completeAt:   2,
+			numFragments: 3,
+		},
+		{
+			name:         "duplicate",
+			fragments:    []fragment{{1, 30, 0, 10}, {1, 30, 0, 10}, {1, 30, 10, 30}},
+			completeAt:   2,
+			numFragments: 3,
+			duplicates:   1,
+		},
+		{
+			name:         "overlapping",
+			fragments:    []fragment{{1, 30, 0, 15}, {1, 30, 10, 25}, {1, 30, 5, 20}, {1, 30, 20, 30}},
+			completeAt:   3,
+			numFragments: 4,
+			duplicates:   1,
+		},
+		{
+			name:       "incomplete",
+			fragments:  []fragment{{1, 30, 0, 5}, {1, 30, 10, 20}},
+			completeAt: -1,
+			missing:    []ByteRange{{5, 10}, {20, 30}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testFrameData(30)
+			r := NewReassembler(time.Second, 1<<10, 1<<12)
+			now := time.Now()
+			for i, f := range tt.fragments {
+				frame, err := r.Add(f.packet(data), now)
+				if err != nil {
+					t.Fatalf("fragment %d: %v", i, err)
+				}
+				if i != tt.completeAt {
+					if frame != nil {
+						t.Fatalf("fragment %d completed the frame", i)
+					}
+					continue
+				}
+				if frame == nil {
+					t.Fatalf("fragment %d did not complete the frame", i)
+				}
+				if !bytes.Equal(frame.Data, data) {
+					t.Errorf("data = %v, want %v", frame.Data, data)
+				}
+				if !frame.Stats.Complete || frame.Stats.ReceivedBytes != 30 {
+					t.Errorf("stats = %+v, want 30 complete bytes", frame.Stats)
+				}
+				if frame.Stats.Fragments != tt.numFragments || frame.Stats.Duplicates != tt.duplicates {
+					t.Errorf("fragments = %d, duplicates = %d, want %d, %d", frame.Stats.Fragments, frame.Stats.Duplicates, tt.numFragments, tt.duplicates)
+				}
+			}
+			if missing := r.Missing(1); !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("missing = %v, want %v", missing, tt.missing)
+			}
+		})
+	}
+}
+
+func TestReassemblerRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet *FramePacket
+	}{
+		{
+			name:   "oversized frame",
+			packet: &FramePacket{FrameNr: 1, FrameLen: 101, SeqLen: 1, Data: []byte{0}},
+		},
+		{
+			name:   "fragment beyond frame",
+			packet: &FramePacket{FrameNr: 1, FrameLen: 10, SeqOffset: 8, SeqLen: 4, Data: make([]byte, 4)},
+		},
+		{
+			name:   "offset overflow",
+			packet: &FramePacket{FrameNr: 1, FrameLen: 10, SeqOffset: 1<<32 - 1, SeqLen: 2, Data: make([]byte, 2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(time.Second, 100, 1000)
+			frame, err := r.Add(tt.packet, time.Now())
+			if !errors.Is(err, ErrMalformedFramePacket) {
+				t.Errorf("err = %v, want %v", err, ErrMalformedFramePacket)
+			}
+			if frame != nil {
+				t.Errorf("frame = %+v, want nil", frame)
+			}
+			if len(r.frames) != 0 || r.buffered != 0 {
+				t.Errorf("%d frames with %d bytes buffered, want none", len(r.frames), r.buffered)
+			}
+		})
+	}
+}
+
+func TestReassemblerExpire(t *testing.T) {
+	data := testFrameData(30)
+	r := NewReassembler(time.Second, 1<<10, 1<<12)
+	start := time.Now()
+	if _, err := r.Add(fragment{1, 30, 0, 10}.packet(data), start); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Add(fragment{2, 30, 0, 10}.packet(data), start.Add(500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped := r.Expire(start.Add(time.Second)); len(dropped) != 0 {
+		t.Fatalf("dropped %d frames before the deadline", len(dropped))
+	}
+	dropped := r.Expire(start.Add(1200 * time.Millisecond))
+	if len(dropped) != 1 || dropped[0].FrameNr != 1 {
+		t.Fatalf("dropped = %+v, want frame 1", dropped)
+	}
+	if dropped[0].Complete || dropped[0].ReceivedBytes != 10 || !reflect.DeepEqual(dropped[0].Missing, []ByteRange{{10, 30}}) {
+		t.Errorf("stats = %+v, want 10 of 30 bytes", dropped[0])
+	}
+	// Late fragments of a dropped frame are ignored
+	frame, err := r.Add(fragment{1, 30, 10, 30}.packet(data), start.Add(1300*time.Millisecond))
+	if err != nil || frame != nil {
+		t.Errorf("late fragment returned %+v, %v", frame, err)
+	}
+	if _, ok := r.frames[1]; ok {
+		t.Error("late fragment restarted frame 1")
+	}
+}
+
+func TestReassemblerBufferLimit(t *testing.T) {
+	data := testFrameData(40)
+	r := NewReassembler(time.Second, 40, 100)
+	now := time.Now()
+	for nr := uint32(1); nr <= 3; nr++ {
+		if _, err := r.Add(fragment{nr, 40, 0, 10}.packet(data), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.buffered > 100 {
+		t.Errorf("buffered = %d, want at most 100", r.buffered)
+	}
+	dropped := r.Expire(now)
+	if len(dropped) != 1 || dropped[0].FrameNr != 1 || dropped[0].Complete {
+		t.Fatalf("dropped = %+v, want incomplete frame 1", dropped)
+	}
+	if dropped := r.Expire(now); len(dropped) != 0 {
+		t.Errorf("dropped %+v again", dropped)
+	}
+
+	frame, err := r.Add(fragment{2, 40, 10, 40}.packet(data), now)
+	if err != nil || frame == nil {
+		t.Fatalf("frame 2 = %+v, %v, want complete", frame, err)
+	}
+	if r.buffered != 40 {
+		t.Errorf("buffered = %d, want 40", r.buffered)
+	}
+}
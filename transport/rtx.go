@@ -0,0 +1,323 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// PointCloudPayloadType is the payload type of the point cloud codec in our offers
+	PointCloudPayloadType = 5
+	// PointCloudRTXPayloadType is the RFC 4588 retransmission payload type linked to it with apt
+	PointCloudRTXPayloadType = 6
+
+	// RTXOverhead is the original sequence number an RTX packet carries in front of the payload
+	RTXOverhead = 2
+
+	// Number of sent packets that can be retransmitted, has to be a power of two
+	rtxBufferSize = 1024
+	// Number of NACKs that wait to be answered, later NACKs are dropped
+	nackQueueSize = 64
+)
+
+// PointCloudRTXCodecCapability is the retransmission codec of point cloud tracks
+func PointCloudRTXCodecCapability() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{
+		MimeType:    "video/rtx",
+		ClockRate:   90000,
+		SDPFmtpLine: fmt.Sprintf("apt=%d", PointCloudPayloadType),
+	}
+}
+
+// RTXStats counts the retransmissions of a connection, they are not part of the media statistics
+type RTXStats struct {
+	// Packets that were requested by a NACK
+	Requested uint64
+	// Packets that were retransmitted and their size on the wire
+	Packets uint64
+	Bytes   uint64
+	// Packets that were requested but are no longer buffered
+	Missing uint64
+	// NACKs that were dropped because too many were waiting to be answered
+	DroppedNACKs uint64
+}
+
+type rtxStream struct {
+	mux     sync.Mutex
+	writer  interceptor.RTPWriter
+	packets [rtxBufferSize]*rtp.Packet
+	// Zero while the remote did not negotiate RTX, retransmissions then use the original SSRC
+	rtxSSRC        uint32
+	rtxPayloadType uint8
+	rtxSequencer   rtp.Sequencer
+}
+
+// RTXResponderInterceptorFactory creates RTXResponderInterceptors
+type RTXResponderInterceptorFactory struct {
+	onNew func(id string, rtx *RTXResponderInterceptor)
+}
+
+// NewRTXResponderInterceptor replaces the NACK responder, retransmissions are sent on a separate
+// SSRC and payload type (RFC 4588) once SetRTX is called for a stream
+func NewRTXResponderInterceptor() (*RTXResponderInterceptorFactory, error) {
+	return &RTXResponderInterceptorFactory{}, nil
+}
+
+// OnNewPeerConnection sets the callback that receives the interceptor of every new connection
+func (f *RTXResponderInterceptorFactory) OnNewPeerConnection(cb func(id string, rtx *RTXResponderInterceptor)) {
+	f.onNew = cb
+}
+
+func (f *RTXResponderInterceptorFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	i := &RTXResponderInterceptor{
+		streams: make(map[uint32]*rtxStream),
+		pending: make(map[uint32]rtxConfig),
+		nacks:   make(chan *rtcp.TransportLayerNack, nackQueueSize),
+		done:    make(chan struct{}),
+	}
+	go i.loop()
+	if f.onNew != nil {
+		f.onNew(id, i)
+	}
+	return i, nil
+}
+
+type rtxConfig struct {
+	ssrc        uint32
+	payloadType uint8
+}
+
+// RTXResponderInterceptor answers NACKs for outgoing streams. It has to be added to the registry
+// after the TWCC header extension interceptor and the bandwidth estimator, so retransmissions get
+// their own transport-wide sequence numbers and are paced, and before the report and stats
+// interceptors, so retransmissions are not counted as media. NACKs are answered one at a time.
+type RTXResponderInterceptor struct {
+	interceptor.NoOp
+	streamsMux sync.Mutex
+	streams    map[uint32]*rtxStream
+	// RTX configuration of streams that are not bound yet
+	pending map[uint32]rtxConfig
+
+	nacks     chan *rtcp.TransportLayerNack
+	done      chan struct{}
+	closeOnce sync.Once
+
+	requested    atomic.Uint64
+	packets      atomic.Uint64
+	bytes        atomic.Uint64
+	missing      atomic.Uint64
+	droppedNACKs atomic.Uint64
+}
+
+// SetRTX links the retransmission SSRC and payload type to a stream, an rtxSSRC of 0 makes the
+// stream fall back to retransmitting on its own SSRC
+func (r *RTXResponderInterceptor) SetRTX(ssrc, rtxSSRC uint32, rtxPayloadType uint8) {
+	r.streamsMux.Lock()
+	defer r.streamsMux.Unlock()
+	stream, ok := r.streams[ssrc]
+	if !ok {
+		r.pending[ssrc] = rtxConfig{rtxSSRC, rtxPayloadType}
+		return
+	}
+	stream.mux.Lock()
+	stream.rtxSSRC = rtxSSRC
+	stream.rtxPayloadType = rtxPayloadType
+	stream.mux.Unlock()
+}
+
+// Stats returns the retransmission counters of all streams
+func (r *RTXResponderInterceptor) Stats() RTXStats {
+	return RTXStats{
+		Requested:    r.requested.Load(),
+		Packets:      r.packets.Load(),
+		Bytes:        r.bytes.Load(),
+		Missing:      r.missing.Load(),
+		DroppedNACKs: r.droppedNACKs.Load(),
+	}
+}
+
+// Close stops answering NACKs
+func (r *RTXResponderInterceptor) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return nil
+}
+
+func (r *RTXResponderInterceptor) loop() {
+	for {
+		select {
+		case nack := <-r.nacks:
+			r.resend(nack)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *RTXResponderInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		i, attr, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+		pkts, err := attr.GetRTCPPackets(b[:i])
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, rtcpPacket := range pkts {
+			if nack, ok := rtcpPacket.(*rtcp.TransportLayerNack); ok {
+				select {
+				case r.nacks <- nack:
+				default:
+					r.droppedNACKs.Add(1)
+				}
+			}
+		}
+		return i, attr, nil
+	})
+}
+
+func (r *RTXResponderInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if !supportsNACK(info) {
+		return writer
+	}
+	stream := &rtxStream{writer: writer, rtxSequencer: rtp.NewRandomSequencer()}
+	r.streamsMux.Lock()
+	if config, ok := r.pending[info.SSRC]; ok {
+		stream.rtxSSRC = config.ssrc
+		stream.rtxPayloadType = config.payloadType
+		delete(r.pending, info.SSRC)
+	}
+	r.streams[info.SSRC] = stream
+	r.streamsMux.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		packet := &rtp.Packet{Header: header.Clone(), Payload: append([]byte(nil), payload...)}
+		stream.mux.Lock()
+		stream.packets[header.SequenceNumber%rtxBufferSize] = packet
+		stream.mux.Unlock()
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (r *RTXResponderInterceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	r.streamsMux.Lock()
+	delete(r.streams, info.SSRC)
+	r.streamsMux.Unlock()
+}
+
+func (r *RTXResponderInterceptor) resend(nack *rtcp.TransportLayerNack) {
+	r.streamsMux.Lock()
+	stream, ok := r.streams[nack.MediaSSRC]
+	r.streamsMux.Unlock()
+	if !ok {
+		return
+	}
+	for _, pair := range nack.Nacks {
+		pair.Range(func(seq uint16) bool {
+			r.requested.Add(1)
+			stream.mux.Lock()
+			packet := stream.packets[seq%rtxBufferSize]
+			if packet == nil || packet.SequenceNumber != seq {
+				stream.mux.Unlock()
+				r.missing.Add(1)
+				return true
+			}
+			header := packet.Header.Clone()
+			payload := packet.Payload
+			var attributes interceptor.Attributes
+			if stream.rtxSSRC != 0 {
+				// RFC 4588: the original sequence number precedes the original payload
+				payload = make([]byte, 2+len(packet.Payload))
+				binary.BigEndian.PutUint16(payload, seq)
+				copy(payload[2:], packet.Payload)
+				header.SSRC = stream.rtxSSRC
+				header.PayloadType = stream.rtxPayloadType
+				header.SequenceNumber = stream.rtxSequencer.NextSequenceNumber()
+				// The pacer only knows the writers of the media streams
+				attributes = interceptor.Attributes{}
+				attributes.Set(pacerStreamKey, nack.MediaSSRC)
+			}
+			stream.mux.Unlock()
+			n, err := stream.writer.Write(&header, payload, attributes)
+			if err != nil {
+				log.Printf("ssrc=%d event=retransmission_failed reason=%q", nack.MediaSSRC, err)
+				return true
+			}
+			r.packets.Add(1)
+			r.bytes.Add(uint64(n))
+			return true
+		})
+	}
+}
+
+func supportsNACK(info *interceptor.StreamInfo) bool {
+	for _, fb := range info.RTCPFeedback {
+		if fb.Type == "nack" && fb.Parameter == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// AddRTXSSRC announces rtxSSRC as the repair flow of ssrc (a=ssrc-group:FID) in the media
+// section of ssrc. Pion does not signal RTX SSRCs for local tracks on its own.
+func AddRTXSSRC(sdp string, ssrc, rtxSSRC uint32) string {
+	lines := strings.Split(sdp, "\r\n")
+	out := make([]string, 0, len(lines)+4)
+	prefix := "a=ssrc:" + strconv.FormatUint(uint64(ssrc), 10) + " "
+	rtxPrefix := "a=ssrc:" + strconv.FormatUint(uint64(rtxSSRC), 10) + " "
+	var rtxLines []string
+	grouped := false
+	for _, line := range lines {
+		isBase := strings.HasPrefix(line, prefix)
+		if isBase && !grouped {
+			out = append(out, fmt.Sprintf("a=ssrc-group:FID %d %d", ssrc, rtxSSRC))
+			grouped = true
+		}
+		if !isBase && len(rtxLines) > 0 {
+			out = append(out, rtxLines...)
+			rtxLines = nil
+		}
+		out = append(out, line)
+		if isBase {
+			rtxLines = append(rtxLines, rtxPrefix+strings.TrimPrefix(line, prefix))
+		}
+	}
+	out = append(out, rtxLines...)
+	return strings.Join(out, "\r\n")
+}
+
+// RTXPayloadType returns the negotiated payload type that retransmits the point cloud codec,
+// false when the remote did not negotiate RTX
+func RTXPayloadType(codecs []webrtc.RTPCodecParameters) (uint8, bool) {
+	for _, codec := range codecs {
+		if !strings.EqualFold(codec.MimeType, PointCloudCodecCapability().MimeType) {
+			continue
+		}
+		apt := fmt.Sprintf("apt=%d", codec.PayloadType)
+		for _, rtx := range codecs {
+			if !strings.EqualFold(rtx.MimeType, "video/rtx") {
+				continue
+			}
+			for _, param := range strings.Split(rtx.SDPFmtpLine, ";") {
+				if strings.TrimSpace(param) == apt {
+					return uint8(rtx.PayloadType), true
+				}
+			}
+		}
+	}
+	return 0, false
+}